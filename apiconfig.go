@@ -3,19 +3,69 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"sync"
+	"time"
 
+	"github.com/keertirajmalik/chirpy/internal/auth"
 	"github.com/keertirajmalik/chirpy/internal/database"
+	"github.com/keertirajmalik/chirpy/internal/lockout"
+	"github.com/keertirajmalik/chirpy/internal/mailer"
+	"github.com/keertirajmalik/chirpy/internal/oidc"
 )
 
 type apiConfig struct {
-	fileServerHits int
-	DB             *database.DB
-	jwtSecret      string
+	metrics   *metrics
+	DB        *database.DB
+	jwtSecret string
+
+	accessTokenTTL     time.Duration
+	accessTokenMaxTTL  time.Duration
+	refreshTokenTTL    time.Duration
+	refreshTokenMaxTTL time.Duration
+
+	oidc            *oidc.Provider
+	oidcRedirectURL string
+
+	accountLockout *lockout.Tracker
+	ipLockout      *lockout.Tracker
+	// dummyPasswordHash is checked against when a login names an unknown
+	// email so the response takes as long as for a wrong password.
+	dummyPasswordHash string
+
+	passwordPolicy auth.PasswordPolicy
+
+	mailer    mailer.Mailer
+	publicURL string
+
+	accountDeletionGracePeriod time.Duration
+
+	// polkaKey authenticates webhooks from Polka, our payment provider.
+	polkaKey string
+
+	maxChirpLength    int
+	maxRedChirpLength int
+	badWords          map[string]struct{}
+
+	// background tracks work that outlives a request, so shutdown can wait
+	// for it.
+	background sync.WaitGroup
+}
+
+// tokenLifetime returns the lifetime requested by the client, falling back to
+// def when nothing was requested and capping it at max.
+func tokenLifetime(requestedSeconds int, def, max time.Duration) time.Duration {
+	if requestedSeconds <= 0 {
+		return def
+	}
+	if requestedSeconds > int(max/time.Second) {
+		return max
+	}
+	return time.Duration(requestedSeconds) * time.Second
 }
 
 func (cfg *apiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		cfg.fileServerHits++
+		cfg.metrics.fileServerHits.Inc()
 		next.ServeHTTP(w, r)
 	})
 }
@@ -33,11 +83,11 @@ func (cfg *apiConfig) handleMetrics(writer http.ResponseWriter, request *http.Re
 
 </html>`
 
-	writer.Write([]byte(fmt.Sprintf(html, cfg.fileServerHits)))
+	writer.Write([]byte(fmt.Sprintf(html, cfg.metrics.fileServerHitCount())))
 }
 
 func (cfg *apiConfig) handleReset(writer http.ResponseWriter, request *http.Request) {
-	cfg.fileServerHits = 0
+	cfg.metrics.resetFileServerHits()
 	writer.WriteHeader(http.StatusOK)
 	writer.Write([]byte("Hit reset to 0"))
 }
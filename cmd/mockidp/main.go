@@ -0,0 +1,198 @@
+// Command mockidp is a minimal OpenID Connect provider for trying out and
+// testing Chirpy's OIDC login locally. It signs every user in as the
+// configured identity without asking for credentials.
+//
+//	go run ./cmd/mockidp -addr localhost:9000 -email alice@example.com
+//
+// and run Chirpy with OIDC_ISSUER=http://localhost:9000,
+// OIDC_CLIENT_ID=chirpy and
+// OIDC_REDIRECT_URL=http://localhost:8080/api/login/oidc/callback.
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const keyID = "mockidp"
+
+type authorization struct {
+	nonce         string
+	codeChallenge string
+	redirectURI   string
+}
+
+type mockIdP struct {
+	issuer        string
+	clientID      string
+	subject       string
+	email         string
+	emailVerified bool
+	key           *rsa.PrivateKey
+
+	mux   sync.Mutex
+	codes map[string]authorization
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:9000", "Address to listen on")
+	clientID := flag.String("client-id", "chirpy", "Client ID to issue tokens to")
+	subject := flag.String("sub", "mock-user-1", "Subject of the signed-in user")
+	email := flag.String("email", "alice@example.com", "Email of the signed-in user")
+	emailVerified := flag.Bool("email-verified", true, "Whether the email is reported as verified")
+	flag.Parse()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		slog.Error("Couldn't generate signing key", "error", err)
+		os.Exit(1)
+	}
+
+	idp := &mockIdP{
+		issuer:        "http://" + *addr,
+		clientID:      *clientID,
+		subject:       *subject,
+		email:         *email,
+		emailVerified: *emailVerified,
+		key:           key,
+		codes:         map[string]authorization{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", idp.handleDiscovery)
+	mux.HandleFunc("GET /jwks", idp.handleJWKS)
+	mux.HandleFunc("GET /authorize", idp.handleAuthorize)
+	mux.HandleFunc("POST /token", idp.handleToken)
+
+	slog.Info("Mock IdP serving", "issuer", idp.issuer)
+	err = http.ListenAndServe(*addr, mux)
+	slog.Error("Server stopped", "error", err)
+	os.Exit(1)
+}
+
+func (idp *mockIdP) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"issuer":                                idp.issuer,
+		"authorization_endpoint":                idp.issuer + "/authorize",
+		"token_endpoint":                        idp.issuer + "/token",
+		"jwks_uri":                              idp.issuer + "/jwks",
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+	})
+}
+
+func (idp *mockIdP) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kid": keyID,
+			"kty": "RSA",
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+		}},
+	})
+}
+
+// handleAuthorize approves every request immediately and redirects straight
+// back to the client with a code.
+func (idp *mockIdP) handleAuthorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != idp.clientID {
+		http.Error(w, "unknown client", http.StatusBadRequest)
+		return
+	}
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		http.Error(w, "invalid redirect_uri", http.StatusBadRequest)
+		return
+	}
+
+	code := randomString()
+	idp.mux.Lock()
+	idp.codes[code] = authorization{
+		nonce:         query.Get("nonce"),
+		codeChallenge: query.Get("code_challenge"),
+		redirectURI:   redirect.String(),
+	}
+	idp.mux.Unlock()
+
+	params := redirect.Query()
+	params.Set("code", code)
+	params.Set("state", query.Get("state"))
+	redirect.RawQuery = params.Encode()
+
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (idp *mockIdP) handleToken(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+
+	idp.mux.Lock()
+	authz, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	idp.mux.Unlock()
+
+	if !ok || authz.redirectURI != r.PostForm.Get("redirect_uri") {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if authz.codeChallenge != base64.RawURLEncoding.EncodeToString(challenge[:]) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            idp.issuer,
+		"sub":            idp.subject,
+		"aud":            idp.clientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          authz.nonce,
+		"email":          idp.email,
+		"email_verified": idp.emailVerified,
+	})
+	token.Header["kid"] = keyID
+
+	idToken, err := token.SignedString(idp.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"access_token": randomString(),
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, payload any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(payload)
+}
+
+func randomString() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
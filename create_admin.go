@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/database"
+)
+
+// runCreateAdmin bootstraps the first admin, run as
+//
+//	chirpy create-admin -email admin@example.com < password.txt
+//
+// An existing user is promoted and keeps their password. Otherwise a new user
+// is created with the password read from the first line of stdin, so it
+// doesn't end up in shell history.
+func runCreateAdmin(ctx context.Context, db *database.DB, policy auth.PasswordPolicy, args []string) error {
+	flags := flag.NewFlagSet("create-admin", flag.ExitOnError)
+	email := flags.String("email", "", "Email address of the admin")
+	flags.Parse(args)
+
+	*email = database.NormalizeEmail(*email)
+	if !isValidEmail(*email) {
+		return errors.New("-email must be a valid email address")
+	}
+
+	user, err := db.GetUserByEmail(ctx, *email)
+	if errors.Is(err, database.ErrNotExist) {
+		fmt.Fprintln(os.Stderr, "Password:")
+		password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && password == "" {
+			return fmt.Errorf("couldn't read password: %w", err)
+		}
+		password = strings.TrimRight(password, "\r\n")
+
+		err = policy.Validate(password)
+		if err != nil {
+			return err
+		}
+
+		hashedPassword, err := auth.HashPassword(ctx, password)
+		if err != nil {
+			return err
+		}
+
+		user, err = db.CreateUser(ctx, *email, hashedPassword)
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+
+	_, err = db.SetRole(ctx, user.ID, auth.RoleAdmin)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is now an admin\n", *email)
+	return nil
+}
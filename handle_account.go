@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// handleUsersDelete schedules the caller's account for deletion after the
+// grace period, during which they can still log in and restore it.
+func (cfg *apiConfig) handleUsersDelete(writer http.ResponseWriter, request *http.Request) {
+	type parameters struct {
+		CurrentPassword string `json:"current_password"`
+	}
+
+	caller, ok := cfg.authorizeSession(writer, request)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithDecodeError(writer, request, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUser(request.Context(), caller.UserID)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't get user", err)
+		return
+	}
+
+	if !cfg.reauthenticate(writer, request, user, params.CurrentPassword) {
+		return
+	}
+
+	user, err = cfg.DB.ScheduleUserDeletion(request.Context(), user.ID, time.Now().UTC().Add(cfg.accountDeletionGracePeriod))
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't delete user", err)
+		return
+	}
+
+	respondWithJson(writer, http.StatusAccepted, userFromDB(user))
+}
+
+func (cfg *apiConfig) handleUsersRestore(writer http.ResponseWriter, request *http.Request) {
+	caller, ok := cfg.authorizeSession(writer, request)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.DB.CancelUserDeletion(request.Context(), caller.UserID)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't restore user", err)
+		return
+	}
+
+	respondWithJson(writer, http.StatusOK, userFromDB(user))
+}
+
+// handleUsersExport sends the caller a zip archive of everything stored
+// about them. Password hashes and other secrets are left out.
+func (cfg *apiConfig) handleUsersExport(writer http.ResponseWriter, request *http.Request) {
+	caller, ok := cfg.authorizeSession(writer, request)
+	if !ok {
+		return
+	}
+
+	data, err := cfg.DB.GetUserData(request.Context(), caller.UserID)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't get user data", err)
+		return
+	}
+
+	data.User.HashedPassword = ""
+	data.User.TOTPSecret = ""
+	data.User.RecoveryCodes = nil
+	for i := range data.RefreshTokens {
+		data.RefreshTokens[i].Token = ""
+	}
+	for i := range data.APITokens {
+		data.APITokens[i].HashedToken = ""
+	}
+	for i := range data.OAuthClients {
+		data.OAuthClients[i].HashedSecret = ""
+	}
+
+	sort.Slice(data.Chirps, func(i, j int) bool {
+		return data.Chirps[i].ID < data.Chirps[j].ID
+	})
+	sort.Slice(data.APITokens, func(i, j int) bool {
+		return data.APITokens[i].ID < data.APITokens[j].ID
+	})
+
+	writer.Header().Set("Content-Type", "application/zip")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%d.zip"`, caller.UserID))
+	writer.WriteHeader(http.StatusOK)
+
+	// Chirpy doesn't store any files for users, so the archive only holds
+	// their data as JSON.
+	archive := zip.NewWriter(writer)
+	file, err := archive.Create("data.json")
+	if err == nil {
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(data)
+	}
+	if err == nil {
+		err = archive.Close()
+	}
+	if err != nil {
+		requestLogger(request).Error("Couldn't write export", "error", err)
+	}
+}
+
+// purgeDeletedUsers removes accounts whose deletion grace period has ended,
+// checking every interval until ctx is done.
+func (cfg *apiConfig) purgeDeletedUsers(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		purged, err := cfg.DB.PurgeDeletedUsers(ctx, time.Now().UTC())
+		if err != nil {
+			slog.Error("Couldn't purge deleted users", "error", err)
+		} else if purged > 0 {
+			slog.Info("Purged deleted users", "count", purged)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/database"
+)
+
+// handleUserUnlock clears the failed login count for an account so its owner
+// can log in again before the lockout expires.
+func (cfg *apiConfig) handleUserUnlock(writer http.ResponseWriter, request *http.Request) {
+	userID, err := strconv.Atoi(request.PathValue("userID"))
+	if err != nil {
+		respondWithError(writer, request, problemUserNotFound, "Invalid user ID")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(request.Context(), userID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(writer, request, problemUserNotFound, "User not found")
+			return
+		}
+
+		respondWithInternalError(writer, request, "Couldn't get user", err)
+		return
+	}
+
+	cfg.accountLockout.Reset(accountLockoutKey(user.Email))
+
+	writer.WriteHeader(http.StatusNoContent)
+}
+
+func (cfg *apiConfig) handleUserSetRole(writer http.ResponseWriter, request *http.Request) {
+	type parameters struct {
+		Role string `json:"role"`
+	}
+
+	caller, err := principalFromContext(request.Context())
+	if err != nil {
+		respondWithAuthError(writer, request, err)
+		return
+	}
+
+	userID, err := strconv.Atoi(request.PathValue("userID"))
+	if err != nil {
+		respondWithError(writer, request, problemUserNotFound, "Invalid user ID")
+		return
+	}
+
+	// Stops the last admin from accidentally locking everyone out.
+	if userID == caller.UserID {
+		respondWithError(writer, request, problemValidation, "You can't change your own role")
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithDecodeError(writer, request, err)
+		return
+	}
+
+	if !auth.IsValidRole(params.Role) {
+		respondWithValidationError(writer, request, fieldError{Field: "role", Code: "unknown", Message: "Unknown role"})
+		return
+	}
+
+	user, err := cfg.DB.SetRole(request.Context(), userID, params.Role)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(writer, request, problemUserNotFound, "User not found")
+			return
+		}
+
+		respondWithInternalError(writer, request, "Couldn't update user", err)
+		return
+	}
+
+	// Sessions carry the role, so sign the user out to apply the change
+	// once their current access token expires.
+	err = cfg.DB.RevokeUserRefreshTokens(request.Context(), user.ID)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't revoke sessions", err)
+		return
+	}
+
+	respondWithJson(writer, http.StatusOK, userFromDB(user))
+}
+
+// handleModeratorChirpDelete removes any user's chirp.
+func (cfg *apiConfig) handleModeratorChirpDelete(writer http.ResponseWriter, request *http.Request) {
+	chirpID, err := strconv.Atoi(request.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(writer, request, problemChirpNotFound, "Invalid chirp ID")
+		return
+	}
+
+	dbChirp, err := cfg.DB.GetChirp(request.Context(), chirpID)
+	if err != nil {
+		respondWithError(writer, request, problemChirpNotFound, "Couldn't get chirp")
+		return
+	}
+
+	err = cfg.DB.DeleteChirp(request.Context(), dbChirp.ID, dbChirp.AuthorID)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't delete chirp", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/database"
+)
+
+func (cfg *apiConfig) handleBlocksList(writer http.ResponseWriter, request *http.Request) {
+	cfg.listRelationships(writer, request, database.RelationshipBlock)
+}
+
+func (cfg *apiConfig) handleBlock(writer http.ResponseWriter, request *http.Request) {
+	cfg.setRelationship(writer, request, database.RelationshipBlock, true)
+}
+
+func (cfg *apiConfig) handleUnblock(writer http.ResponseWriter, request *http.Request) {
+	cfg.setRelationship(writer, request, database.RelationshipBlock, false)
+}
+
+func (cfg *apiConfig) handleMutesList(writer http.ResponseWriter, request *http.Request) {
+	cfg.listRelationships(writer, request, database.RelationshipMute)
+}
+
+func (cfg *apiConfig) handleMute(writer http.ResponseWriter, request *http.Request) {
+	cfg.setRelationship(writer, request, database.RelationshipMute, true)
+}
+
+func (cfg *apiConfig) handleUnmute(writer http.ResponseWriter, request *http.Request) {
+	cfg.setRelationship(writer, request, database.RelationshipMute, false)
+}
+
+func (cfg *apiConfig) listRelationships(writer http.ResponseWriter, request *http.Request, relationship string) {
+	caller, ok := cfg.authorize(writer, request, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.DB.GetUser(request.Context(), caller.UserID)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't get user", err)
+		return
+	}
+
+	ids := user.MutedUserIDs
+	if relationship == database.RelationshipBlock {
+		ids = user.BlockedUserIDs
+	}
+
+	profiles := []Profile{}
+	for _, id := range ids {
+		other, err := cfg.DB.GetUser(request.Context(), id)
+		if err != nil {
+			continue
+		}
+		profiles = append(profiles, profileFromDB(other))
+	}
+
+	respondWithJson(writer, http.StatusOK, profiles)
+}
+
+func (cfg *apiConfig) setRelationship(writer http.ResponseWriter, request *http.Request, relationship string, enabled bool) {
+	caller, ok := cfg.authorize(writer, request, auth.ScopeUsersWrite)
+	if !ok {
+		return
+	}
+
+	targetID, err := strconv.Atoi(request.PathValue("userID"))
+	if err != nil {
+		respondWithError(writer, request, problemUserNotFound, "Invalid user ID")
+		return
+	}
+
+	if targetID == caller.UserID {
+		respondWithError(writer, request, problemValidation, "You can't "+relationship+" yourself")
+		return
+	}
+
+	_, err = cfg.DB.SetRelationship(request.Context(), caller.UserID, targetID, relationship, enabled)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(writer, request, problemUserNotFound, "User not found")
+			return
+		}
+
+		respondWithInternalError(writer, request, "Couldn't update user", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
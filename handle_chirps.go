@@ -1,20 +1,22 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
 	"net/http"
 	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/database"
 )
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorID int    `json:"author_id"`
+	ID       int      `json:"id"`
+	Body     string   `json:"body"`
+	AuthorID int      `json:"author_id"`
+	Author   *Profile `json:"author,omitempty"`
 }
 
 func (cfg *apiConfig) handleChirpCreate(writer http.ResponseWriter, request *http.Request) {
@@ -22,21 +24,60 @@ func (cfg *apiConfig) handleChirpCreate(writer http.ResponseWriter, request *htt
 		Body string `json:"body"`
 	}
 
-	token, err := auth.GetBearerToken(request.Header)
+	caller, ok := cfg.authorize(writer, request, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+	userID := caller.UserID
+
+	decoder := json.NewDecoder(request.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithDecodeError(writer, request, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUser(request.Context(), userID)
 	if err != nil {
-		respondWithError(writer, http.StatusUnauthorized, "Couldn't find JWT")
+		respondWithInternalError(writer, request, "Couldn't get user", err)
 		return
 	}
 
-	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
+	cleaned, err := cfg.validateChirp(params.Body, cfg.chirpMaxLength(user))
 	if err != nil {
-		respondWithError(writer, http.StatusUnauthorized, "Couldn't validate JWT")
+		respondWithValidationError(writer, request, err)
 		return
 	}
 
-	userID, err := strconv.Atoi(subject)
+	chirp, err := cfg.DB.CreateChirp(request.Context(), cleaned, userID)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't parse user ID")
+		respondWithInternalError(writer, request, "Couldn't create chirp", err)
+		return
+	}
+	cfg.metrics.chirpsCreated.Inc()
+
+	respondWithJson(writer, http.StatusCreated, Chirp{
+		ID:       chirp.ID,
+		Body:     chirp.Body,
+		AuthorID: chirp.AuthorID,
+	})
+}
+
+// handleChirpUpdate lets a Chirpy Red member edit one of their chirps.
+func (cfg *apiConfig) handleChirpUpdate(writer http.ResponseWriter, request *http.Request) {
+	type parameters struct {
+		Body string `json:"body"`
+	}
+
+	caller, ok := cfg.authorize(writer, request, auth.ScopeChirpsWrite)
+	if !ok {
+		return
+	}
+
+	chirpID, err := strconv.Atoi(request.PathValue("chirpID"))
+	if err != nil {
+		respondWithError(writer, request, problemChirpNotFound, "Invalid chirp ID")
 		return
 	}
 
@@ -44,36 +85,65 @@ func (cfg *apiConfig) handleChirpCreate(writer http.ResponseWriter, request *htt
 	params := parameters{}
 	err = decoder.Decode(&params)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't decode parameters")
+		respondWithDecodeError(writer, request, err)
 		return
 	}
 
-	cleaned, err := validateChirp(params.Body)
+	dbChirp, err := cfg.DB.GetChirp(request.Context(), chirpID)
 	if err != nil {
-		respondWithError(writer, http.StatusBadRequest, err.Error())
+		respondWithError(writer, request, problemChirpNotFound, "Couldn't get chirp")
 		return
 	}
 
-	chirp, err := cfg.DB.CreateChirp(cleaned, userID)
+	if dbChirp.AuthorID != caller.UserID {
+		respondWithError(writer, request, problemForbidden, "You can't edit this chirp")
+		return
+	}
+
+	user, err := cfg.DB.GetUser(request.Context(), caller.UserID)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't create chirp")
+		respondWithInternalError(writer, request, "Couldn't get user", err)
 		return
 	}
 
-	respondWithJson(writer, http.StatusCreated, Chirp{
+	if !user.IsChirpyRed {
+		respondWithError(writer, request, problemChirpyRedRequired, "Editing chirps requires Chirpy Red")
+		return
+	}
+
+	cleaned, err := cfg.validateChirp(params.Body, cfg.chirpMaxLength(user))
+	if err != nil {
+		respondWithValidationError(writer, request, err)
+		return
+	}
+
+	chirp, err := cfg.DB.UpdateChirp(request.Context(), chirpID, cleaned)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't update chirp", err)
+		return
+	}
+
+	respondWithJson(writer, http.StatusOK, Chirp{
 		ID:       chirp.ID,
 		Body:     chirp.Body,
 		AuthorID: chirp.AuthorID,
 	})
 }
 
-func validateChirp(body string) (string, error) {
-	if len(body) > 140 {
-		return "", errors.New("Chirp is too long")
+// chirpMaxLength is longer for Chirpy Red members.
+func (cfg *apiConfig) chirpMaxLength(user database.User) int {
+	if user.IsChirpyRed {
+		return cfg.maxRedChirpLength
+	}
+	return cfg.maxChirpLength
+}
+
+func (cfg *apiConfig) validateChirp(body string, maxLength int) (string, error) {
+	if len(body) > maxLength {
+		return "", fieldError{Field: "body", Code: "too_long", Message: "Chirp is too long"}
 	}
-	badWords := map[string]struct{}{"kerfuffle": {}, "sharbert": {}, "fornax": {}}
 
-	cleaned := getCleanedBody(body, badWords)
+	cleaned := getCleanedBody(body, cfg.badWords)
 
 	return cleaned, nil
 }
@@ -92,9 +162,14 @@ func getCleanedBody(body string, badWords map[string]struct{}) string {
 }
 
 func (cfg *apiConfig) handleChirpGet(w http.ResponseWriter, r *http.Request) {
-	dbChirps, err := cfg.DB.GetChirps()
+	hidden, ok := cfg.hiddenAuthors(w, r)
+	if !ok {
+		return
+	}
+
+	dbChirps, err := cfg.DB.GetChirps(r.Context())
 	if err != nil {
-		respondWithError(w, http.StatusInternalServerError, "Couldn't retrieve chirps")
+		respondWithInternalError(w, r, "Couldn't retrieve chirps", err)
 		return
 	}
 
@@ -106,17 +181,20 @@ func (cfg *apiConfig) handleChirpGet(w http.ResponseWriter, r *http.Request) {
 	if strings.TrimSpace(authorID) != "" {
 		authorIDInt, err := strconv.Atoi(authorID)
 		if err != nil {
-			respondWithError(w, http.StatusNotFound, "Invalid authod Id")
+			respondWithValidationError(w, r, fieldError{Field: "author_id", Code: "invalid", Message: "Invalid author ID"})
 			return
 		}
 
 		for _, chirp := range dbChirps {
-			if chirp.AuthorID == authorIDInt {
+			if chirp.AuthorID == authorIDInt && !hidden[chirp.AuthorID] {
 				chirps = append(chirps, Chirp{ID: chirp.ID, Body: chirp.Body, AuthorID: chirp.AuthorID})
 			}
 		}
 	} else {
 		for _, dbChirp := range dbChirps {
+			if hidden[dbChirp.AuthorID] {
+				continue
+			}
 			chirps = append(chirps, Chirp{ID: dbChirp.ID, Body: dbChirp.Body, AuthorID: dbChirp.AuthorID})
 		}
 	}
@@ -129,68 +207,119 @@ func (cfg *apiConfig) handleChirpGet(w http.ResponseWriter, r *http.Request) {
 		return chirps[i].ID < chirps[j].ID
 	})
 
+	if r.URL.Query().Get("expand") == "author" {
+		err = cfg.expandAuthors(r.Context(), chirps)
+		if err != nil {
+			respondWithInternalError(w, r, "Couldn't retrieve authors", err)
+			return
+		}
+	}
+
 	respondWithJson(w, http.StatusOK, chirps)
 }
 
+// hiddenAuthors returns the authors whose chirps are hidden from the caller
+// because of a block or mute. Anonymous callers see everything.
+func (cfg *apiConfig) hiddenAuthors(w http.ResponseWriter, r *http.Request) (map[int]bool, bool) {
+	caller, err := principalFromContext(r.Context())
+	if err != nil {
+		return nil, true
+	}
+
+	hidden, err := cfg.DB.HiddenAuthors(r.Context(), caller.UserID)
+	if err != nil {
+		respondWithInternalError(w, r, "Couldn't retrieve chirps", err)
+		return nil, false
+	}
+
+	return hidden, true
+}
+
+// expandAuthors fills in the public profile of each chirp's author.
+func (cfg *apiConfig) expandAuthors(ctx context.Context, chirps []Chirp) error {
+	ids := make([]int, 0, len(chirps))
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.AuthorID)
+	}
+
+	users, err := cfg.DB.GetUsers(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	authors := make(map[int]*Profile, len(users))
+	for id, user := range users {
+		profile := profileFromDB(user)
+		authors[id] = &profile
+	}
+	for i := range chirps {
+		chirps[i].Author = authors[chirps[i].AuthorID]
+	}
+	return nil
+}
+
 func (cfg *apiConfig) handleChirpGetSpecific(w http.ResponseWriter, r *http.Request) {
 	chirpId, err := strconv.Atoi(r.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Invalid chirp ID")
+		respondWithError(w, r, problemChirpNotFound, "Invalid chirp ID")
 		return
 	}
 
-	dbChirps, err := cfg.DB.GetChirp(chirpId)
-	if err != nil {
-		respondWithError(w, http.StatusNotFound, "Chirp not found")
+	hidden, ok := cfg.hiddenAuthors(w, r)
+	if !ok {
 		return
 	}
 
-	respondWithJson(w, http.StatusOK, Chirp{
+	dbChirps, err := cfg.DB.GetChirp(r.Context(), chirpId)
+	if err != nil || hidden[dbChirps.AuthorID] {
+		respondWithError(w, r, problemChirpNotFound, "Chirp not found")
+		return
+	}
+
+	chirps := []Chirp{{
 		ID:       dbChirps.ID,
 		Body:     dbChirps.Body,
 		AuthorID: dbChirps.AuthorID,
-	})
-}
+	}}
 
-func (cfg *apiConfig) handleChirpDelete(writer http.ResponseWriter, request *http.Request) {
-	token, err := auth.GetBearerToken(request.Header)
-	if err != nil {
-		respondWithError(writer, http.StatusUnauthorized, "Couldn't find JWT")
-		return
+	if r.URL.Query().Get("expand") == "author" {
+		err = cfg.expandAuthors(r.Context(), chirps)
+		if err != nil {
+			respondWithInternalError(w, r, "Couldn't retrieve author", err)
+			return
+		}
 	}
 
-	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(writer, http.StatusUnauthorized, "Couldn't validate JWT")
-		return
-	}
+	respondWithJson(w, http.StatusOK, chirps[0])
+}
 
-	userID, err := strconv.Atoi(subject)
-	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't parse user ID")
+func (cfg *apiConfig) handleChirpDelete(writer http.ResponseWriter, request *http.Request) {
+	caller, ok := cfg.authorize(writer, request, auth.ScopeChirpsWrite)
+	if !ok {
 		return
 	}
+	userID := caller.UserID
 
 	chirpID, err := strconv.Atoi(request.PathValue("chirpID"))
 	if err != nil {
-		respondWithError(writer, http.StatusNotFound, "Invalid chrip ID")
+		respondWithError(writer, request, problemChirpNotFound, "Invalid chirp ID")
 		return
 	}
 
-	dbChirp, err := cfg.DB.GetChirp(chirpID)
+	dbChirp, err := cfg.DB.GetChirp(request.Context(), chirpID)
 	if err != nil {
-		respondWithError(writer, http.StatusNotFound, "Couldn't get chirp")
+		respondWithError(writer, request, problemChirpNotFound, "Couldn't get chirp")
 		return
 	}
 
 	if dbChirp.AuthorID != userID {
-		respondWithError(writer, http.StatusForbidden, "You can't delete this chirp")
+		respondWithError(writer, request, problemForbidden, "You can't delete this chirp")
 		return
 	}
 
-	err = cfg.DB.DeleteChirp(chirpID, userID)
+	err = cfg.DB.DeleteChirp(request.Context(), chirpID, userID)
 	if err != nil {
-		respondWithError(writer, http.StatusForbidden, "Couldn't delete chirp")
+		respondWithInternalError(writer, request, "Couldn't delete chirp", err)
 		return
 	}
 
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/database"
+	"github.com/keertirajmalik/chirpy/internal/mailer"
+)
+
+const (
+	emailVerificationTTL = 24 * time.Hour
+	passwordResetTTL     = time.Hour
+	mailSendTimeout      = 30 * time.Second
+)
+
+// sendVerificationEmail mails the user a link to prove they own their email
+// address. Mail is sent in the background so slow delivery doesn't hold up
+// the request, or reveal through timing whether an account exists.
+func (cfg *apiConfig) sendVerificationEmail(request *http.Request, user database.User) {
+	token, err := auth.MakeSingleUseToken(user.ID, user.Email, auth.PurposeEmailVerification, cfg.jwtSecret, emailVerificationTTL)
+	if err != nil {
+		requestLogger(request).Error("Couldn't create verification token", "error", err)
+		return
+	}
+
+	cfg.sendMail(request, mailer.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: "Confirm this is your email address by opening the link below:\n\n" +
+			cfg.publicURL + "/app/verify-email.html?token=" + url.QueryEscape(token) + "\n\n" +
+			"The link expires in 24 hours. If you didn't sign up for Chirpy you can ignore this email.",
+	})
+}
+
+func (cfg *apiConfig) sendPasswordResetEmail(request *http.Request, user database.User) {
+	token, err := auth.MakeSingleUseToken(user.ID, user.Email, auth.PurposePasswordReset, cfg.jwtSecret, passwordResetTTL)
+	if err != nil {
+		requestLogger(request).Error("Couldn't create password reset token", "user_id", user.ID, "error", err)
+		return
+	}
+
+	cfg.sendMail(request, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: "Someone asked to reset the password for your Chirpy account. Choose a new password by opening the link below:\n\n" +
+			cfg.publicURL + "/app/reset-password.html?token=" + url.QueryEscape(token) + "\n\n" +
+			"The link expires in an hour. If you didn't ask for this you can ignore this email.",
+	})
+}
+
+func (cfg *apiConfig) sendMail(request *http.Request, msg mailer.Message) {
+	logger := requestLogger(request)
+	cfg.background.Add(1)
+	go func() {
+		defer cfg.background.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), mailSendTimeout)
+		defer cancel()
+
+		err := cfg.mailer.Send(ctx, msg)
+		if err != nil {
+			logger.Error("Couldn't send email", "subject", msg.Subject, "error", err)
+		}
+	}()
+}
+
+// consumeSingleUseToken checks a token sent by email and marks it used. It
+// returns the user it was issued to, provided their email hasn't changed
+// since it was sent.
+func (cfg *apiConfig) consumeSingleUseToken(ctx context.Context, token, purpose string) (database.User, error) {
+	claims, err := auth.ParseSingleUseToken(token, purpose, cfg.jwtSecret)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	user, err := cfg.DB.GetUser(ctx, userID)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	if user.Email != claims.Email {
+		return database.User{}, errors.New("email has changed since the token was sent")
+	}
+
+	err = cfg.DB.ConsumeToken(ctx, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return database.User{}, err
+	}
+
+	return user, nil
+}
+
+func (cfg *apiConfig) handleVerifyEmailRequest(writer http.ResponseWriter, request *http.Request) {
+	caller, ok := cfg.authorizeSession(writer, request)
+	if !ok {
+		return
+	}
+
+	user, err := cfg.DB.GetUser(request.Context(), caller.UserID)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't get user", err)
+		return
+	}
+
+	if user.EmailVerified {
+		respondWithError(writer, request, problemConflict, "Email is already verified")
+		return
+	}
+
+	cfg.sendVerificationEmail(request, user)
+
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handleVerifyEmailConfirm(writer http.ResponseWriter, request *http.Request) {
+	type parameters struct {
+		Token string `json:"token"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithDecodeError(writer, request, err)
+		return
+	}
+
+	user, err := cfg.consumeSingleUseToken(request.Context(), params.Token, auth.PurposeEmailVerification)
+	if err != nil {
+		respondWithError(writer, request, problemInvalidToken, "Invalid or expired verification token")
+		return
+	}
+
+	user, err = cfg.DB.MarkEmailVerified(request.Context(), user.ID)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't verify email", err)
+		return
+	}
+
+	respondWithJson(writer, http.StatusOK, userFromDB(user))
+}
+
+// handlePasswordResetRequest always responds the same way so it can't be
+// used to find out which emails have accounts.
+func (cfg *apiConfig) handlePasswordResetRequest(writer http.ResponseWriter, request *http.Request) {
+	type parameters struct {
+		Email string `json:"email"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithDecodeError(writer, request, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUserByEmail(request.Context(), params.Email)
+	if err == nil {
+		cfg.sendPasswordResetEmail(request, user)
+	} else if !errors.Is(err, database.ErrNotExist) {
+		requestLogger(request).Error("Couldn't look up user for password reset", "error", err)
+	}
+
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+func (cfg *apiConfig) handlePasswordResetConfirm(writer http.ResponseWriter, request *http.Request) {
+	type parameters struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithDecodeError(writer, request, err)
+		return
+	}
+
+	// Check the policy first so a rejected password doesn't use up the token.
+	err = cfg.validatePassword(params.Password)
+	if err != nil {
+		respondWithValidationError(writer, request, err)
+		return
+	}
+
+	user, err := cfg.consumeSingleUseToken(request.Context(), params.Token, auth.PurposePasswordReset)
+	if err != nil {
+		respondWithError(writer, request, problemInvalidToken, "Invalid or expired reset token")
+		return
+	}
+
+	hashedPassword, err := auth.HashPassword(request.Context(), params.Password)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't hash password", err)
+		return
+	}
+
+	_, err = cfg.DB.UpdatePassword(request.Context(), user.ID, hashedPassword)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't update password", err)
+		return
+	}
+
+	// Whoever knew the old password shouldn't stay signed in.
+	err = cfg.DB.RevokeUserRefreshTokens(request.Context(), user.ID)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't revoke sessions", err)
+		return
+	}
+
+	// The reset link proves ownership of the email, so lift any lockout.
+	cfg.accountLockout.Reset(accountLockoutKey(user.Email))
+
+	writer.WriteHeader(http.StatusNoContent)
+}
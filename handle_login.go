@@ -1,112 +1,278 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/database"
 )
 
+const mfaTokenTTL = 5 * time.Minute
+
+type loginResponse struct {
+	User
+	sessionTokens
+}
+
 func (cfg *apiConfig) handleLogin(writer http.ResponseWriter, request *http.Request) {
 	type parameters struct {
 		Email            string `json:"email"`
 		Password         string `json:"password"`
 		ExpiresInSeconds int    `json:"expires_in_seconds"`
-	}
 
-	type response struct {
-		User
-		Token        string `json:"token"`
-		RefreshToken string `json:"refresh_token"`
+		RefreshExpiresInSeconds int `json:"refresh_expires_in_seconds"`
 	}
 
 	decoder := json.NewDecoder(request.Body)
 	params := parameters{}
 	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't decode parameters")
+		respondWithDecodeError(writer, request, err)
 		return
 	}
 
-	user, err := cfg.DB.GetUserByEmail(params.Email)
+	if !cfg.checkLoginLockout(writer, request, params.Email) {
+		return
+	}
 
+	user, err := cfg.authenticatePassword(request, params.Email, params.Password)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't get user")
+		if errors.Is(err, errInvalidCredentials) {
+			cfg.recordLoginFailure()
+			respondWithError(writer, request, problemInvalidCredentials, "Incorrect email or password")
+			return
+		}
+
+		respondWithInternalError(writer, request, "Couldn't get user", err)
 		return
 	}
 
-	err = auth.CheckPasswordHash(params.Password, user.HashedPassword)
+	cfg.completeLogin(writer, request, user, params.ExpiresInSeconds, params.RefreshExpiresInSeconds)
+}
 
-	if err != nil {
-		respondWithError(writer, http.StatusUnauthorized, "Invalid password")
+// completeLogin finishes a login whose first factor has been checked, after
+// checkLoginLockout reserved the attempt. Users with two-factor
+// authentication get an MFA token to exchange for tokens along with a TOTP
+// code, however they signed in.
+func (cfg *apiConfig) completeLogin(writer http.ResponseWriter, request *http.Request, user database.User, expiresInSeconds, refreshExpiresInSeconds int) {
+	type mfaResponse struct {
+		MFARequired bool   `json:"mfa_required"`
+		MFAToken    string `json:"mfa_token"`
+	}
+
+	if user.TOTPEnabled {
+		cfg.releaseLoginAttempt(request, user.Email)
+
+		mfaToken, err := auth.MakeMFAToken(user.ID, cfg.jwtSecret, mfaTokenTTL)
+		if err != nil {
+			respondWithInternalError(writer, request, "Couldn't create MFA token", err)
+			return
+		}
+
+		respondWithJson(writer, http.StatusOK, mfaResponse{
+			MFARequired: true,
+			MFAToken:    mfaToken,
+		})
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	cfg.recordLoginSuccess(request, user.Email)
+	cfg.respondWithTokens(writer, request, user, expiresInSeconds, refreshExpiresInSeconds)
+}
+
+var errInvalidCredentials = errors.New("incorrect email or password")
+
+// authenticatePassword checks an email and password, returning
+// errInvalidCredentials whether the email is unknown or the password is
+// wrong. Unknown emails are still checked against a dummy hash so the two
+// cases take the same time and can't be told apart.
+func (cfg *apiConfig) authenticatePassword(request *http.Request, email, password string) (database.User, error) {
+	user, err := cfg.DB.GetUserByEmail(request.Context(), email)
+	if err != nil && !errors.Is(err, database.ErrNotExist) {
+		return database.User{}, err
+	}
+
+	if err != nil || user.HashedPassword == "" {
+		auth.CheckPasswordHash(request.Context(), password, cfg.dummyPasswordHash)
+		return database.User{}, errInvalidCredentials
+	}
+
+	needsRehash, err := auth.CheckPasswordHash(request.Context(), password, user.HashedPassword)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't create JWT")
-		return
+		return database.User{}, errInvalidCredentials
 	}
 
-	refreshToken, err := auth.MakeRefreshToken()
+	// This is the only time we see the plain password, so take the chance to
+	// upgrade hashes made with an old algorithm or parameters.
+	if needsRehash {
+		hashedPassword, err := auth.HashPassword(request.Context(), password)
+		if err == nil {
+			user, err = cfg.DB.UpdatePassword(request.Context(), user.ID, hashedPassword)
+		}
+		if err != nil {
+			requestLogger(request).Warn("Couldn't rehash password", "user_id", user.ID, "error", err)
+		}
+	}
+
+	return user, nil
+}
+
+// checkLoginLockout responds with 429 and returns false if the account or
+// the client's IP address is locked out after too many failed logins.
+// Otherwise the attempt counts as failed until recordLoginSuccess or
+// releaseLoginAttempt is called.
+func (cfg *apiConfig) checkLoginLockout(writer http.ResponseWriter, request *http.Request, email string) bool {
+	retryAfter := cfg.reserveLoginAttempt(request, email)
+	if retryAfter <= 0 {
+		return true
+	}
+
+	writer.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	respondWithError(writer, request, problemTooManyAttempts, "Too many failed login attempts, try again later")
+	return false
+}
+
+// reserveLoginAttempt counts a login attempt against both the account and
+// the client's IP address, returning how long to wait if either is locked.
+func (cfg *apiConfig) reserveLoginAttempt(request *http.Request, email string) time.Duration {
+	accountRetryAfter := cfg.accountLockout.Attempt(accountLockoutKey(email))
+	ipRetryAfter := cfg.ipLockout.Attempt(clientIP(request))
+	return max(accountRetryAfter, ipRetryAfter)
+}
+
+// recordLoginFailure only updates metrics, as the reserved attempt already
+// counts as failed.
+func (cfg *apiConfig) recordLoginFailure() {
+	cfg.metrics.loginsFailed.Inc()
+}
+
+// recordLoginSuccess clears the account's failures and takes back the
+// attempt reserved against the client's IP address.
+func (cfg *apiConfig) recordLoginSuccess(request *http.Request, email string) {
+	cfg.accountLockout.Reset(accountLockoutKey(email))
+	cfg.ipLockout.Succeed(clientIP(request))
+}
+
+// releaseLoginAttempt takes back a reserved attempt whose password was right
+// but which isn't a complete login, such as one still needing a TOTP code.
+func (cfg *apiConfig) releaseLoginAttempt(request *http.Request, email string) {
+	cfg.accountLockout.Succeed(accountLockoutKey(email))
+	cfg.ipLockout.Succeed(clientIP(request))
+}
+
+// accountLockoutKey is keyed on the email rather than the user ID so that
+// unknown emails are locked out exactly like real ones.
+func accountLockoutKey(email string) string {
+	return database.NormalizeEmail(email)
+}
+
+func clientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't create refresh token")
-		return
+		return request.RemoteAddr
 	}
+	return host
+}
 
-	err = cfg.DB.SaveRefreshToken(user.ID, refreshToken)
+// sessionTokens are the access and refresh token pair handed to a user who
+// has fully authenticated.
+type sessionTokens struct {
+	Token                 string    `json:"token"`
+	ExpiresAt             time.Time `json:"expires_at"`
+	RefreshToken          string    `json:"refresh_token"`
+	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
+}
+
+// respondWithTokens issues a new access and refresh token pair for a user who
+// has fully authenticated.
+func (cfg *apiConfig) respondWithTokens(writer http.ResponseWriter, request *http.Request, user database.User, expiresInSeconds, refreshExpiresInSeconds int) {
+	tokens, err := cfg.issueTokens(request.Context(), user, expiresInSeconds, refreshExpiresInSeconds)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't save refresh token")
+		respondWithInternalError(writer, request, "Couldn't create tokens", err)
 		return
 	}
 
-	respondWithJson(writer, http.StatusOK, response{
-		User: User{
-			ID:    user.ID,
-			Email: user.Email},
-		Token:        accessToken,
-		RefreshToken: refreshToken,
+	respondWithJson(writer, http.StatusOK, loginResponse{
+		User:          userFromDB(user),
+		sessionTokens: tokens,
 	})
 }
 
+func (cfg *apiConfig) issueTokens(ctx context.Context, user database.User, expiresInSeconds, refreshExpiresInSeconds int) (sessionTokens, error) {
+	accessTokenTTL := tokenLifetime(expiresInSeconds, cfg.accessTokenTTL, cfg.accessTokenMaxTTL)
+	expiresAt := time.Now().UTC().Add(accessTokenTTL)
+	accessToken, err := auth.MakeJWT(user.ID, user.Role, cfg.jwtSecret, accessTokenTTL)
+	if err != nil {
+		return sessionTokens{}, err
+	}
+
+	refreshTokenTTL := tokenLifetime(refreshExpiresInSeconds, cfg.refreshTokenTTL, cfg.refreshTokenMaxTTL)
+	refreshTokenExpiresAt := time.Now().UTC().Add(refreshTokenTTL)
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return sessionTokens{}, err
+	}
+
+	err = cfg.DB.SaveRefreshToken(ctx, user.ID, refreshToken, refreshTokenExpiresAt)
+	if err != nil {
+		return sessionTokens{}, err
+	}
+
+	return sessionTokens{
+		Token:                 accessToken,
+		ExpiresAt:             expiresAt,
+		RefreshToken:          refreshToken,
+		RefreshTokenExpiresAt: refreshTokenExpiresAt,
+	}, nil
+}
+
 func (cfg *apiConfig) handleRefresh(writer http.ResponseWriter, request *http.Request) {
 	type response struct {
-		Token string `json:"token"`
+		Token     string    `json:"token"`
+		ExpiresAt time.Time `json:"expires_at"`
 	}
 
 	refreshToken, err := auth.GetBearerToken(request.Header)
 	if err != nil {
-		respondWithError(writer, http.StatusUnauthorized, "Couldn't find token")
+		respondWithError(writer, request, problemInvalidToken, "Couldn't find token")
 		return
 	}
 
-	user, err := cfg.DB.UserForRefershToken(refreshToken)
+	user, err := cfg.DB.UserForRefershToken(request.Context(), refreshToken)
 	if err != nil {
-		respondWithError(writer, http.StatusUnauthorized, "No token")
+		respondWithError(writer, request, problemInvalidToken, "No token")
 		return
 	}
 
-	accessToken, err := auth.MakeJWT(user.ID, cfg.jwtSecret, time.Hour)
+	expiresAt := time.Now().UTC().Add(cfg.accessTokenTTL)
+	accessToken, err := auth.MakeJWT(user.ID, user.Role, cfg.jwtSecret, cfg.accessTokenTTL)
 	if err != nil {
-		respondWithError(writer, http.StatusUnauthorized, "Couldn't validate token")
+		respondWithInternalError(writer, request, "Couldn't create token", err)
 		return
 	}
 	respondWithJson(writer, http.StatusOK, response{
-		Token: accessToken,
+		Token:     accessToken,
+		ExpiresAt: expiresAt,
 	})
 }
 
 func (cfg *apiConfig) handleRevoke(writer http.ResponseWriter, request *http.Request) {
 	refreshToken, err := auth.GetBearerToken(request.Header)
 	if err != nil {
-		respondWithError(writer, http.StatusUnauthorized, "Invalid header")
+		respondWithError(writer, request, problemInvalidToken, "Invalid header")
 		return
 	}
 
-	err = cfg.DB.RevokeToken(refreshToken)
+	err = cfg.DB.RevokeToken(request.Context(), refreshToken)
 	if err != nil {
-		respondWithError(writer, http.StatusUnauthorized, "Couldn't revoke session")
+		respondWithError(writer, request, problemInvalidToken, "Couldn't revoke session")
 		return
 	}
 
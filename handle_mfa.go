@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/database"
+)
+
+const (
+	totpIssuer        = "Chirpy"
+	recoveryCodeCount = 10
+)
+
+func (cfg *apiConfig) handleTOTPEnroll(writer http.ResponseWriter, request *http.Request) {
+	type response struct {
+		Secret     string `json:"secret"`
+		OTPAuthURI string `json:"otpauth_uri"`
+	}
+
+	caller, ok := cfg.authorizeSession(writer, request)
+	if !ok {
+		return
+	}
+	userID := caller.UserID
+
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't generate TOTP secret", err)
+		return
+	}
+
+	user, err := cfg.DB.SetPendingTOTPSecret(request.Context(), userID, secret)
+	if err != nil {
+		if errors.Is(err, database.ErrAlreadyExists) {
+			respondWithError(writer, request, problemConflict, "Two-factor authentication is already enabled")
+			return
+		}
+
+		respondWithInternalError(writer, request, "Couldn't save TOTP secret", err)
+		return
+	}
+
+	respondWithJson(writer, http.StatusOK, response{
+		Secret:     secret,
+		OTPAuthURI: auth.TOTPURI(secret, totpIssuer, user.Email),
+	})
+}
+
+func (cfg *apiConfig) handleTOTPConfirm(writer http.ResponseWriter, request *http.Request) {
+	type parameters struct {
+		Code string `json:"code"`
+	}
+
+	type response struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+
+	caller, ok := cfg.authorizeSession(writer, request)
+	if !ok {
+		return
+	}
+	userID := caller.UserID
+
+	decoder := json.NewDecoder(request.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithDecodeError(writer, request, err)
+		return
+	}
+
+	user, err := cfg.DB.GetUser(request.Context(), userID)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't get user", err)
+		return
+	}
+
+	if user.TOTPEnabled {
+		respondWithError(writer, request, problemConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	if user.TOTPSecret == "" {
+		respondWithError(writer, request, problemConflict, "Two-factor authentication enrolment hasn't been started")
+		return
+	}
+
+	counter, err := auth.ValidateTOTP(params.Code, user.TOTPSecret, time.Now())
+	if err != nil {
+		respondWithError(writer, request, problemInvalidMFACode, "Invalid TOTP code")
+		return
+	}
+
+	recoveryCodes, err := auth.GenerateRecoveryCodes(recoveryCodeCount)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't generate recovery codes", err)
+		return
+	}
+
+	hashedRecoveryCodes := make([]string, 0, len(recoveryCodes))
+	for _, code := range recoveryCodes {
+		hashedRecoveryCodes = append(hashedRecoveryCodes, auth.HashToken(code))
+	}
+
+	err = cfg.DB.EnableTOTP(request.Context(), userID, counter, hashedRecoveryCodes)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't enable two-factor authentication", err)
+		return
+	}
+
+	respondWithJson(writer, http.StatusOK, response{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// handleLoginMFA completes a login for an account with two-factor
+// authentication by exchanging the challenge token from handleLogin and a
+// TOTP or recovery code for an access and refresh token pair.
+func (cfg *apiConfig) handleLoginMFA(writer http.ResponseWriter, request *http.Request) {
+	type parameters struct {
+		MFAToken         string `json:"mfa_token"`
+		Code             string `json:"code"`
+		RecoveryCode     string `json:"recovery_code"`
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
+
+		RefreshExpiresInSeconds int `json:"refresh_expires_in_seconds"`
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithDecodeError(writer, request, err)
+		return
+	}
+
+	subject, err := auth.ValidateMFAToken(params.MFAToken, cfg.jwtSecret)
+	if err != nil {
+		respondWithError(writer, request, problemInvalidToken, "Couldn't validate MFA token")
+		return
+	}
+
+	userID, err := strconv.Atoi(subject)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't parse user ID", err)
+		return
+	}
+
+	user, err := cfg.DB.GetUser(request.Context(), userID)
+	if err != nil {
+		respondWithError(writer, request, problemInvalidToken, "Couldn't get user")
+		return
+	}
+
+	if !user.TOTPEnabled {
+		respondWithError(writer, request, problemInvalidToken, "Two-factor authentication isn't enabled")
+		return
+	}
+
+	if !cfg.checkLoginLockout(writer, request, user.Email) {
+		return
+	}
+
+	switch {
+	case params.Code != "":
+		counter, err := auth.ValidateTOTP(params.Code, user.TOTPSecret, time.Now())
+		if err != nil {
+			cfg.recordLoginFailure()
+			respondWithError(writer, request, problemInvalidMFACode, "Invalid TOTP code")
+			return
+		}
+
+		err = cfg.DB.UseTOTPCounter(request.Context(), user.ID, counter)
+		if err != nil {
+			if errors.Is(err, database.ErrTOTPCodeReused) {
+				respondWithError(writer, request, problemInvalidMFACode, "TOTP code already used")
+				return
+			}
+
+			respondWithInternalError(writer, request, "Couldn't save TOTP code", err)
+			return
+		}
+	case params.RecoveryCode != "":
+		hashedCode := auth.HashToken(auth.NormalizeRecoveryCode(params.RecoveryCode))
+		err = cfg.DB.UseRecoveryCode(request.Context(), user.ID, hashedCode)
+		if err != nil {
+			if errors.Is(err, database.ErrNotExist) {
+				cfg.recordLoginFailure()
+				respondWithError(writer, request, problemInvalidMFACode, "Invalid recovery code")
+				return
+			}
+
+			respondWithInternalError(writer, request, "Couldn't use recovery code", err)
+			return
+		}
+	default:
+		respondWithValidationError(writer, request, fieldError{Field: "code", Code: "required", Message: "A TOTP code or recovery code is required"})
+		return
+	}
+
+	cfg.recordLoginSuccess(request, user.Email)
+	cfg.respondWithTokens(writer, request, user, params.ExpiresInSeconds, params.RefreshExpiresInSeconds)
+}
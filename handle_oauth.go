@@ -0,0 +1,539 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/database"
+)
+
+const (
+	authorizationCodeTTL = 10 * time.Minute
+	defaultOAuthScope    = auth.ScopeChirpsRead
+	maxOAuthClientName   = 100
+)
+
+type OAuthClient struct {
+	ClientID     string    `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (cfg *apiConfig) handleOAuthClientCreate(writer http.ResponseWriter, request *http.Request) {
+	type parameters struct {
+		Name         string   `json:"name"`
+		RedirectURIs []string `json:"redirect_uris"`
+		Public       bool     `json:"public"`
+	}
+
+	type response struct {
+		OAuthClient
+		ClientSecret string `json:"client_secret,omitempty"`
+	}
+
+	caller, ok := cfg.authorizeSession(writer, request)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithDecodeError(writer, request, err)
+		return
+	}
+
+	errs := []error{}
+	name := strings.TrimSpace(params.Name)
+	if name == "" || len(name) > maxOAuthClientName {
+		errs = append(errs, fieldError{Field: "name", Code: "invalid_length", Message: "Client name must be between 1 and 100 characters"})
+	}
+
+	if len(params.RedirectURIs) == 0 {
+		errs = append(errs, fieldError{Field: "redirect_uris", Code: "required", Message: "At least one redirect URI is required"})
+	}
+
+	for _, redirectURI := range params.RedirectURIs {
+		if !isValidRedirectURI(redirectURI) {
+			errs = append(errs, fieldError{Field: "redirect_uris", Code: "invalid", Message: "Invalid redirect URI: " + redirectURI})
+		}
+	}
+
+	err = errors.Join(errs...)
+	if err != nil {
+		respondWithValidationError(writer, request, err)
+		return
+	}
+
+	clientID, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't create client ID", err)
+		return
+	}
+
+	clientSecret := ""
+	hashedSecret := ""
+	if !params.Public {
+		clientSecret, err = auth.MakeRefreshToken()
+		if err != nil {
+			respondWithInternalError(writer, request, "Couldn't create client secret", err)
+			return
+		}
+		hashedSecret = auth.HashToken(clientSecret)
+	}
+
+	client, err := cfg.DB.CreateOAuthClient(request.Context(), clientID, caller.UserID, name, hashedSecret, params.RedirectURIs)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't create client", err)
+		return
+	}
+
+	respondWithJson(writer, http.StatusCreated, response{
+		OAuthClient: OAuthClient{
+			ClientID:     client.ID,
+			Name:         client.Name,
+			RedirectURIs: client.RedirectURIs,
+			CreatedAt:    client.CreatedAt,
+		},
+		ClientSecret: clientSecret,
+	})
+}
+
+// isValidRedirectURI accepts absolute https URIs, and plain http only for
+// loopback addresses used by native apps during development.
+func isValidRedirectURI(redirectURI string) bool {
+	parsed, err := url.Parse(redirectURI)
+	if err != nil || parsed.Host == "" || parsed.Fragment != "" {
+		return false
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		return true
+	case "http":
+		host := parsed.Hostname()
+		return host == "localhost" || host == "127.0.0.1" || host == "::1"
+	default:
+		return false
+	}
+}
+
+// normalizeScope validates a space-separated scope string, removing
+// duplicates. An empty scope falls back to the default.
+func normalizeScope(scope string) (string, bool) {
+	requested := strings.Fields(scope)
+	if len(requested) == 0 {
+		return defaultOAuthScope, true
+	}
+
+	scopes := []string{}
+	for _, s := range requested {
+		if !auth.IsValidScope(s) {
+			return "", false
+		}
+		if !slices.Contains(scopes, s) {
+			scopes = append(scopes, s)
+		}
+	}
+
+	return strings.Join(scopes, " "), true
+}
+
+type authorizeRequest struct {
+	ResponseType        string
+	ClientID            string
+	ClientName          string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+}
+
+func parseAuthorizeRequest(values url.Values) authorizeRequest {
+	return authorizeRequest{
+		ResponseType:        values.Get("response_type"),
+		ClientID:            values.Get("client_id"),
+		RedirectURI:         values.Get("redirect_uri"),
+		Scope:               values.Get("scope"),
+		State:               values.Get("state"),
+		CodeChallenge:       values.Get("code_challenge"),
+		CodeChallengeMethod: values.Get("code_challenge_method"),
+	}
+}
+
+var errUnknownRedirect = errors.New("unknown client or redirect URI")
+
+// validateAuthorizeRequest checks an authorization request and fills in the
+// client name, redirect URI and normalised scope. Problems with the client or
+// redirect URI are returned as an error since they must not be redirected to;
+// any other problem is returned as an OAuth error code to send to the client.
+func (cfg *apiConfig) validateAuthorizeRequest(ctx context.Context, req *authorizeRequest) (string, error) {
+	client, err := cfg.DB.GetOAuthClient(ctx, req.ClientID)
+	if err != nil {
+		return "", errUnknownRedirect
+	}
+
+	if req.RedirectURI == "" && len(client.RedirectURIs) == 1 {
+		req.RedirectURI = client.RedirectURIs[0]
+	}
+	if !slices.Contains(client.RedirectURIs, req.RedirectURI) {
+		return "", errUnknownRedirect
+	}
+	req.ClientName = client.Name
+
+	if req.ResponseType != "code" {
+		return "unsupported_response_type", nil
+	}
+
+	if req.CodeChallenge == "" || req.CodeChallengeMethod != auth.PKCEMethodS256 {
+		return "invalid_request", nil
+	}
+
+	scope, ok := normalizeScope(req.Scope)
+	if !ok {
+		return "invalid_scope", nil
+	}
+	req.Scope = scope
+
+	return "", nil
+}
+
+func (cfg *apiConfig) handleOAuthAuthorize(writer http.ResponseWriter, request *http.Request) {
+	req := parseAuthorizeRequest(request.URL.Query())
+
+	oauthErr, err := cfg.validateAuthorizeRequest(request.Context(), &req)
+	if err != nil {
+		renderConsentError(writer, http.StatusBadRequest, "This application isn't registered correctly with Chirpy.")
+		return
+	}
+	if oauthErr != "" {
+		redirectWithParams(writer, request, req.RedirectURI, url.Values{"error": {oauthErr}, "state": {req.State}})
+		return
+	}
+
+	renderConsent(writer, http.StatusOK, req, "")
+}
+
+func (cfg *apiConfig) handleOAuthAuthorizeSubmit(writer http.ResponseWriter, request *http.Request) {
+	err := request.ParseForm()
+	if err != nil {
+		renderConsentError(writer, http.StatusBadRequest, "Couldn't read the form.")
+		return
+	}
+
+	req := parseAuthorizeRequest(request.PostForm)
+
+	oauthErr, err := cfg.validateAuthorizeRequest(request.Context(), &req)
+	if err != nil {
+		renderConsentError(writer, http.StatusBadRequest, "This application isn't registered correctly with Chirpy.")
+		return
+	}
+	if oauthErr != "" {
+		redirectWithParams(writer, request, req.RedirectURI, url.Values{"error": {oauthErr}, "state": {req.State}})
+		return
+	}
+
+	if request.PostForm.Get("decision") != "approve" {
+		redirectWithParams(writer, request, req.RedirectURI, url.Values{"error": {"access_denied"}, "state": {req.State}})
+		return
+	}
+
+	email := request.PostForm.Get("email")
+	if retryAfter := cfg.reserveLoginAttempt(request, email); retryAfter > 0 {
+		renderConsent(writer, http.StatusTooManyRequests, req, "Too many failed login attempts, try again later.")
+		return
+	}
+
+	user, err := cfg.authenticatePassword(request, email, request.PostForm.Get("password"))
+	if err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			cfg.recordLoginFailure()
+			renderConsent(writer, http.StatusUnauthorized, req, "Incorrect email or password.")
+			return
+		}
+
+		renderConsentError(writer, http.StatusInternalServerError, "Couldn't get user.")
+		return
+	}
+
+	if user.TOTPEnabled {
+		counter, err := auth.ValidateTOTP(request.PostForm.Get("totp"), user.TOTPSecret, time.Now())
+		if err == nil {
+			err = cfg.DB.UseTOTPCounter(request.Context(), user.ID, counter)
+		}
+		if err != nil {
+			cfg.recordLoginFailure()
+			renderConsent(writer, http.StatusUnauthorized, req, "Enter a valid code from your authenticator app.")
+			return
+		}
+	}
+	cfg.recordLoginSuccess(request, email)
+
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		renderConsentError(writer, http.StatusInternalServerError, "Couldn't create authorization code.")
+		return
+	}
+
+	err = cfg.DB.SaveAuthorizationCode(request.Context(), database.AuthorizationCode{
+		HashedCode:          auth.HashToken(code),
+		ClientID:            req.ClientID,
+		UserID:              user.ID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		ExpiresAt:           time.Now().UTC().Add(authorizationCodeTTL),
+	})
+	if err != nil {
+		renderConsentError(writer, http.StatusInternalServerError, "Couldn't save authorization code.")
+		return
+	}
+
+	redirectWithParams(writer, request, req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})
+}
+
+func redirectWithParams(writer http.ResponseWriter, request *http.Request, redirectURI string, params url.Values) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		renderConsentError(writer, http.StatusBadRequest, "Invalid redirect URI.")
+		return
+	}
+
+	query := target.Query()
+	for key, values := range params {
+		if len(values) == 0 || values[0] == "" {
+			continue
+		}
+		query.Set(key, values[0])
+	}
+	target.RawQuery = query.Encode()
+
+	http.Redirect(writer, request, target.String(), http.StatusFound)
+}
+
+func (cfg *apiConfig) handleOAuthToken(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.Header().Set("Pragma", "no-cache")
+
+	err := request.ParseForm()
+	if err != nil {
+		respondWithOAuthError(writer, http.StatusBadRequest, "invalid_request", "Couldn't parse form")
+		return
+	}
+
+	client, ok := cfg.authenticateOAuthClient(request)
+	if !ok {
+		writer.Header().Set("WWW-Authenticate", `Basic realm="chirpy"`)
+		respondWithOAuthError(writer, http.StatusUnauthorized, "invalid_client", "Client authentication failed")
+		return
+	}
+
+	switch request.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.handleAuthorizationCodeGrant(writer, request, client)
+	case "refresh_token":
+		cfg.handleRefreshTokenGrant(writer, request, client)
+	default:
+		respondWithOAuthError(writer, http.StatusBadRequest, "unsupported_grant_type", "Only authorization_code and refresh_token are supported")
+	}
+}
+
+// authenticateOAuthClient identifies the client from HTTP Basic credentials
+// or the request body. Confidential clients must present their secret.
+func (cfg *apiConfig) authenticateOAuthClient(request *http.Request) (database.OAuthClient, bool) {
+	clientID, clientSecret, hasBasic := request.BasicAuth()
+	if !hasBasic {
+		clientID = request.PostForm.Get("client_id")
+		clientSecret = request.PostForm.Get("client_secret")
+	}
+
+	client, err := cfg.DB.GetOAuthClient(request.Context(), clientID)
+	if err != nil {
+		return database.OAuthClient{}, false
+	}
+
+	if client.HashedSecret != "" {
+		hashedSecret := auth.HashToken(clientSecret)
+		if subtle.ConstantTimeCompare([]byte(hashedSecret), []byte(client.HashedSecret)) != 1 {
+			return database.OAuthClient{}, false
+		}
+	}
+
+	return client, true
+}
+
+func (cfg *apiConfig) handleAuthorizationCodeGrant(writer http.ResponseWriter, request *http.Request, client database.OAuthClient) {
+	code, err := cfg.DB.ConsumeAuthorizationCode(request.Context(), auth.HashToken(request.PostForm.Get("code")))
+	if err != nil {
+		respondWithOAuthError(writer, http.StatusBadRequest, "invalid_grant", "Invalid or expired authorization code")
+		return
+	}
+
+	if code.ClientID != client.ID || code.RedirectURI != request.PostForm.Get("redirect_uri") {
+		respondWithOAuthError(writer, http.StatusBadRequest, "invalid_grant", "Authorization code was issued to another client or redirect URI")
+		return
+	}
+
+	if !auth.VerifyPKCE(request.PostForm.Get("code_verifier"), code.CodeChallenge, code.CodeChallengeMethod) {
+		respondWithOAuthError(writer, http.StatusBadRequest, "invalid_grant", "Invalid code verifier")
+		return
+	}
+
+	refreshToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		respondWithOAuthError(writer, http.StatusInternalServerError, "server_error", "Couldn't create refresh token")
+		return
+	}
+
+	err = cfg.DB.SaveClientRefreshToken(request.Context(), code.UserID, refreshToken, client.ID, code.Scope, time.Now().UTC().Add(cfg.refreshTokenTTL))
+	if err != nil {
+		respondWithOAuthError(writer, http.StatusInternalServerError, "server_error", "Couldn't save refresh token")
+		return
+	}
+
+	cfg.respondWithClientToken(writer, code.UserID, client.ID, code.Scope, refreshToken)
+}
+
+func (cfg *apiConfig) handleRefreshTokenGrant(writer http.ResponseWriter, request *http.Request, client database.OAuthClient) {
+	refreshToken, err := cfg.DB.GetRefreshToken(request.Context(), request.PostForm.Get("refresh_token"))
+	if err != nil || refreshToken.ClientID != client.ID {
+		respondWithOAuthError(writer, http.StatusBadRequest, "invalid_grant", "Invalid or expired refresh token")
+		return
+	}
+
+	// A client may ask for a narrower scope than it was originally granted.
+	scope := refreshToken.Scope
+	if requested := request.PostForm.Get("scope"); requested != "" {
+		granted := strings.Fields(refreshToken.Scope)
+		for _, s := range strings.Fields(requested) {
+			if !slices.Contains(granted, s) {
+				respondWithOAuthError(writer, http.StatusBadRequest, "invalid_scope", "Requested scope exceeds the original grant")
+				return
+			}
+		}
+		scope, _ = normalizeScope(requested)
+	}
+
+	cfg.respondWithClientToken(writer, refreshToken.UserID, client.ID, scope, "")
+}
+
+func (cfg *apiConfig) respondWithClientToken(writer http.ResponseWriter, userID int, clientID, scope, refreshToken string) {
+	type response struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token,omitempty"`
+		Scope        string `json:"scope"`
+	}
+
+	accessToken, err := auth.MakeClientJWT(userID, clientID, scope, cfg.jwtSecret, cfg.accessTokenTTL)
+	if err != nil {
+		respondWithOAuthError(writer, http.StatusInternalServerError, "server_error", "Couldn't create access token")
+		return
+	}
+
+	respondWithJson(writer, http.StatusOK, response{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(cfg.accessTokenTTL / time.Second),
+		RefreshToken: refreshToken,
+		Scope:        scope,
+	})
+}
+
+// respondWithOAuthError writes an error in the format required by RFC 6749
+// section 5.2, which OAuth client libraries expect instead of our usual one.
+func respondWithOAuthError(writer http.ResponseWriter, code int, errorCode, description string) {
+	type errorResponse struct {
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description,omitempty"`
+		RequestID        string `json:"request_id,omitempty"`
+	}
+
+	respondWithJson(writer, code, errorResponse{
+		Error:            errorCode,
+		ErrorDescription: description,
+		RequestID:        writer.Header().Get(requestIDHeader),
+	})
+}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<html>
+
+<head>
+    <title>Authorize {{.Request.ClientName}} - Chirpy</title>
+</head>
+
+<body>
+    {{if .Fatal}}
+    <h1>Something went wrong</h1>
+    <p>{{.Error}}</p>
+    {{else}}
+    <h1>Authorize {{.Request.ClientName}}</h1>
+    <p>{{.Request.ClientName}} would like to access your Chirpy account with these permissions:</p>
+    <ul>
+        {{range .Scopes}}<li>{{.}}</li>{{end}}
+    </ul>
+    {{if .Error}}<p><strong>{{.Error}}</strong></p>{{end}}
+    <form method="post" action="/oauth/authorize">
+        <input type="hidden" name="response_type" value="{{.Request.ResponseType}}">
+        <input type="hidden" name="client_id" value="{{.Request.ClientID}}">
+        <input type="hidden" name="redirect_uri" value="{{.Request.RedirectURI}}">
+        <input type="hidden" name="scope" value="{{.Request.Scope}}">
+        <input type="hidden" name="state" value="{{.Request.State}}">
+        <input type="hidden" name="code_challenge" value="{{.Request.CodeChallenge}}">
+        <input type="hidden" name="code_challenge_method" value="{{.Request.CodeChallengeMethod}}">
+        <p><label>Email <input type="email" name="email" required></label></p>
+        <p><label>Password <input type="password" name="password" required></label></p>
+        <p><label>Authenticator code (if enabled) <input type="text" name="totp" inputmode="numeric" autocomplete="one-time-code"></label></p>
+        <button type="submit" name="decision" value="approve">Allow</button>
+        <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+    </form>
+    {{end}}
+</body>
+
+</html>`))
+
+type consentPage struct {
+	Request authorizeRequest
+	Scopes  []string
+	Error   string
+	Fatal   bool
+}
+
+func renderConsent(writer http.ResponseWriter, code int, req authorizeRequest, errMsg string) {
+	renderConsentPage(writer, code, consentPage{
+		Request: req,
+		Scopes:  strings.Fields(req.Scope),
+		Error:   errMsg,
+	})
+}
+
+func renderConsentError(writer http.ResponseWriter, code int, errMsg string) {
+	renderConsentPage(writer, code, consentPage{
+		Error: errMsg,
+		Fatal: true,
+	})
+}
+
+func renderConsentPage(writer http.ResponseWriter, code int, page consentPage) {
+	writer.Header().Set("Content-Type", "text/html; charset=utf-8")
+	// The page collects credentials, so it must never be framed by the client.
+	writer.Header().Set("X-Frame-Options", "DENY")
+	writer.Header().Set("Content-Security-Policy", "frame-ancestors 'none'")
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(code)
+	consentTemplate.Execute(writer, page)
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/database"
+)
+
+const (
+	oidcCookieName = "chirpy_oidc"
+	oidcFlowTTL    = 10 * time.Minute
+)
+
+// handleOIDCLogin starts a login with the external identity provider. The
+// state, nonce and PKCE verifier are kept in a signed cookie so the callback
+// can check them without any server-side session storage.
+func (cfg *apiConfig) handleOIDCLogin(writer http.ResponseWriter, request *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(writer, request, problemNotFound, "OIDC login isn't configured")
+		return
+	}
+
+	state, errState := auth.MakeRefreshToken()
+	nonce, errNonce := auth.MakeRefreshToken()
+	verifier, errVerifier := auth.MakeRefreshToken()
+	if errState != nil || errNonce != nil || errVerifier != nil {
+		respondWithInternalError(writer, request, "Couldn't start login", errors.Join(errState, errNonce, errVerifier))
+		return
+	}
+
+	challenge := sha256.Sum256([]byte(verifier))
+	authURL, err := cfg.oidc.AuthCodeURL(request.Context(), state, nonce, base64.RawURLEncoding.EncodeToString(challenge[:]))
+	if err != nil {
+		respondWithError(writer, request, problemUpstream, "Couldn't reach identity provider")
+		return
+	}
+
+	expiresAt := time.Now().Add(oidcFlowTTL)
+	value := strings.Join([]string{state, nonce, verifier, strconv.FormatInt(expiresAt.Unix(), 10)}, " ")
+	http.SetCookie(writer, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    auth.SignValue(value, cfg.jwtSecret),
+		Path:     "/api/login/oidc",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   request.TLS != nil || strings.HasPrefix(cfg.oidcRedirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(writer, request, authURL, http.StatusFound)
+}
+
+func (cfg *apiConfig) handleOIDCCallback(writer http.ResponseWriter, request *http.Request) {
+	if cfg.oidc == nil {
+		respondWithError(writer, request, problemNotFound, "OIDC login isn't configured")
+		return
+	}
+
+	http.SetCookie(writer, &http.Cookie{
+		Name:   oidcCookieName,
+		Path:   "/api/login/oidc",
+		MaxAge: -1,
+	})
+
+	query := request.URL.Query()
+	if providerErr := query.Get("error"); providerErr != "" {
+		respondWithError(writer, request, problemSSOFailed, "Identity provider returned "+providerErr)
+		return
+	}
+
+	state, nonce, verifier, err := cfg.readOIDCCookie(request)
+	if err != nil {
+		respondWithError(writer, request, problemInvalidLoginState, "Login session is missing or expired")
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(state), []byte(query.Get("state"))) != 1 {
+		respondWithError(writer, request, problemInvalidLoginState, "Login state doesn't match")
+		return
+	}
+
+	rawIDToken, err := cfg.oidc.Exchange(request.Context(), query.Get("code"), verifier)
+	if err != nil {
+		respondWithError(writer, request, problemUpstream, "Couldn't exchange authorization code")
+		return
+	}
+
+	claims, err := cfg.oidc.VerifyIDToken(request.Context(), rawIDToken, nonce)
+	if err != nil {
+		respondWithError(writer, request, problemSSOFailed, "Couldn't verify ID token")
+		return
+	}
+
+	user, err := cfg.userForExternalIdentity(request.Context(), database.ExternalIdentity{
+		Issuer:  cfg.oidc.Issuer(),
+		Subject: claims.Subject,
+	}, claims.Email, claims.EmailVerified)
+	if err != nil {
+		if errors.Is(err, errUnverifiedEmail) {
+			respondWithError(writer, request, problemForbidden, "Identity provider didn't return a verified email")
+			return
+		}
+
+		respondWithInternalError(writer, request, "Couldn't get user", err)
+		return
+	}
+
+	// Signing in through the identity provider stands in for the password,
+	// but lockouts and two-factor authentication still apply.
+	if !cfg.checkLoginLockout(writer, request, user.Email) {
+		return
+	}
+
+	cfg.completeLogin(writer, request, user, 0, 0)
+}
+
+func (cfg *apiConfig) readOIDCCookie(request *http.Request) (state, nonce, verifier string, err error) {
+	cookie, err := request.Cookie(oidcCookieName)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	value, err := auth.VerifySignedValue(cookie.Value, cfg.jwtSecret)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	parts := strings.Split(value, " ")
+	if len(parts) != 4 {
+		return "", "", "", fmt.Errorf("malformed %s cookie", oidcCookieName)
+	}
+
+	expiresAt, err := strconv.ParseInt(parts[3], 10, 64)
+	if err != nil || time.Now().Unix() > expiresAt {
+		return "", "", "", fmt.Errorf("expired %s cookie", oidcCookieName)
+	}
+
+	return parts[0], parts[1], parts[2], nil
+}
+
+var errUnverifiedEmail = errors.New("identity provider email isn't verified")
+
+// userForExternalIdentity finds the user linked to an external identity. On
+// first login the identity is linked to the user with the same email, or a
+// new password-less user is created, but only if the provider has verified
+// the email so nobody can take over an account by claiming its address.
+func (cfg *apiConfig) userForExternalIdentity(ctx context.Context, identity database.ExternalIdentity, email string, emailVerified bool) (database.User, error) {
+	user, err := cfg.DB.GetUserByExternalIdentity(ctx, identity)
+	if err == nil {
+		return user, nil
+	}
+	if !errors.Is(err, database.ErrNotExist) {
+		return database.User{}, err
+	}
+
+	email = database.NormalizeEmail(email)
+	if email == "" || !emailVerified {
+		return database.User{}, errUnverifiedEmail
+	}
+
+	user, err = cfg.DB.GetUserByEmail(ctx, email)
+	if err == nil {
+		return cfg.DB.LinkExternalIdentity(ctx, user.ID, identity)
+	}
+	if !errors.Is(err, database.ErrNotExist) {
+		return database.User{}, err
+	}
+
+	return cfg.DB.CreateExternalUser(ctx, email, identity)
+}
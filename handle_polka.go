@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/database"
+)
+
+const polkaEventUserUpgraded = "user.upgraded"
+
+// handlePolkaWebhook receives payment events from Polka. Only upgrades are
+// acted on; every other event is acknowledged so Polka doesn't retry it.
+func (cfg *apiConfig) handlePolkaWebhook(writer http.ResponseWriter, request *http.Request) {
+	type parameters struct {
+		Event string `json:"event"`
+		Data  struct {
+			UserID int `json:"user_id"`
+		} `json:"data"`
+	}
+
+	apiKey, err := auth.GetAPIKey(request.Header)
+	if err != nil || cfg.polkaKey == "" || subtle.ConstantTimeCompare([]byte(apiKey), []byte(cfg.polkaKey)) != 1 {
+		respondWithError(writer, request, problemInvalidAPIKey, "Couldn't validate API key")
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := parameters{}
+	err = decoder.Decode(&params)
+	if err != nil {
+		respondWithDecodeError(writer, request, err)
+		return
+	}
+
+	if params.Event != polkaEventUserUpgraded {
+		writer.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	_, err = cfg.DB.UpgradeUser(request.Context(), params.Data.UserID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(writer, request, problemUserNotFound, "User not found")
+			return
+		}
+
+		respondWithInternalError(writer, request, "Couldn't upgrade user", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
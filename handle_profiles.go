@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/keertirajmalik/chirpy/internal/database"
+)
+
+const (
+	maxDisplayNameLength = 50
+	maxBioLength         = 160
+	maxAvatarURLLength   = 2048
+)
+
+var handlePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,30}$`)
+
+// reservedHandles can't be claimed because they clash with routes under
+// /api/users.
+var reservedHandles = map[string]struct{}{"me": {}, "admin": {}, "verify-email": {}}
+
+// Profile is the public view of a user, safe to show to anyone.
+type Profile struct {
+	ID          int    `json:"id"`
+	Handle      string `json:"handle,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+func profileFromDB(user database.User) Profile {
+	return Profile{
+		ID:          user.ID,
+		Handle:      user.Handle,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		AvatarURL:   user.AvatarURL,
+	}
+}
+
+// validateProfile returns a fieldError for each invalid field, joined
+// together.
+func validateProfile(profile database.Profile) error {
+	errs := []error{}
+
+	if profile.Handle != "" {
+		if !handlePattern.MatchString(profile.Handle) {
+			errs = append(errs, fieldError{Field: "handle", Code: "invalid", Message: "Handle must be 3 to 30 letters, numbers or underscores"})
+		} else if _, ok := reservedHandles[strings.ToLower(profile.Handle)]; ok {
+			errs = append(errs, fieldError{Field: "handle", Code: "reserved", Message: "Handle is reserved"})
+		}
+	}
+
+	if utf8.RuneCountInString(profile.DisplayName) > maxDisplayNameLength {
+		errs = append(errs, fieldError{Field: "display_name", Code: "too_long", Message: "Display name must be at most 50 characters"})
+	}
+
+	if utf8.RuneCountInString(profile.Bio) > maxBioLength {
+		errs = append(errs, fieldError{Field: "bio", Code: "too_long", Message: "Bio must be at most 160 characters"})
+	}
+
+	if profile.AvatarURL != "" {
+		avatarURL, err := url.Parse(profile.AvatarURL)
+		if err != nil || avatarURL.Scheme != "https" || avatarURL.Host == "" || len(profile.AvatarURL) > maxAvatarURLLength {
+			errs = append(errs, fieldError{Field: "avatar_url", Code: "invalid", Message: "Avatar URL must be an https URL"})
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func (cfg *apiConfig) handleProfileGet(writer http.ResponseWriter, request *http.Request) {
+	user, err := cfg.DB.GetUserByHandle(request.Context(), request.PathValue("handle"))
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(writer, request, problemUserNotFound, "User not found")
+			return
+		}
+
+		respondWithInternalError(writer, request, "Couldn't get user", err)
+		return
+	}
+
+	respondWithJson(writer, http.StatusOK, profileFromDB(user))
+}
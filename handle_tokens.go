@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/database"
+)
+
+const maxAPITokenNameLength = 100
+
+type APIToken struct {
+	ID        int       `json:"id"`
+	Name      string    `json:"name"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (cfg *apiConfig) handleAPITokenCreate(writer http.ResponseWriter, request *http.Request) {
+	type parameters struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+
+	type response struct {
+		APIToken
+		Token string `json:"token"`
+	}
+
+	caller, ok := cfg.authorizeSession(writer, request)
+	if !ok {
+		return
+	}
+
+	decoder := json.NewDecoder(request.Body)
+	params := parameters{}
+	err := decoder.Decode(&params)
+	if err != nil {
+		respondWithDecodeError(writer, request, err)
+		return
+	}
+
+	errs := []error{}
+	name := strings.TrimSpace(params.Name)
+	if name == "" || len(name) > maxAPITokenNameLength {
+		errs = append(errs, fieldError{Field: "name", Code: "invalid_length", Message: "Token name must be between 1 and 100 characters"})
+	}
+
+	if len(params.Scopes) == 0 {
+		errs = append(errs, fieldError{Field: "scopes", Code: "required", Message: "At least one scope is required"})
+	}
+
+	for _, scope := range params.Scopes {
+		if !auth.IsValidScope(scope) {
+			errs = append(errs, fieldError{Field: "scopes", Code: "unknown", Message: "Unknown scope: " + scope})
+		}
+	}
+
+	err = errors.Join(errs...)
+	if err != nil {
+		respondWithValidationError(writer, request, err)
+		return
+	}
+
+	token, err := auth.MakeAPIToken()
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't create token", err)
+		return
+	}
+
+	apiToken, err := cfg.DB.CreateAPIToken(request.Context(), caller.UserID, name, auth.HashToken(token), params.Scopes)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't save token", err)
+		return
+	}
+
+	respondWithJson(writer, http.StatusCreated, response{
+		APIToken: APIToken{
+			ID:        apiToken.ID,
+			Name:      apiToken.Name,
+			Scopes:    apiToken.Scopes,
+			CreatedAt: apiToken.CreatedAt,
+		},
+		Token: token,
+	})
+}
+
+func (cfg *apiConfig) handleAPITokenList(writer http.ResponseWriter, request *http.Request) {
+	caller, ok := cfg.authorizeSession(writer, request)
+	if !ok {
+		return
+	}
+
+	dbAPITokens, err := cfg.DB.GetAPITokens(request.Context(), caller.UserID)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't retrieve tokens", err)
+		return
+	}
+
+	apiTokens := []APIToken{}
+	for _, apiToken := range dbAPITokens {
+		apiTokens = append(apiTokens, APIToken{
+			ID:        apiToken.ID,
+			Name:      apiToken.Name,
+			Scopes:    apiToken.Scopes,
+			CreatedAt: apiToken.CreatedAt,
+		})
+	}
+
+	respondWithJson(writer, http.StatusOK, apiTokens)
+}
+
+func (cfg *apiConfig) handleAPITokenRevoke(writer http.ResponseWriter, request *http.Request) {
+	caller, ok := cfg.authorizeSession(writer, request)
+	if !ok {
+		return
+	}
+
+	tokenID, err := strconv.Atoi(request.PathValue("tokenID"))
+	if err != nil {
+		respondWithError(writer, request, problemTokenNotFound, "Invalid token ID")
+		return
+	}
+
+	err = cfg.DB.RevokeAPIToken(request.Context(), tokenID, caller.UserID)
+	if err != nil {
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(writer, request, problemTokenNotFound, "Token not found")
+			return
+		}
+
+		respondWithInternalError(writer, request, "Couldn't revoke token", err)
+		return
+	}
+
+	writer.WriteHeader(http.StatusNoContent)
+}
@@ -4,16 +4,48 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
-	"strconv"
+	"net/mail"
+	"strings"
+	"time"
 
 	"github.com/keertirajmalik/chirpy/internal/auth"
 	"github.com/keertirajmalik/chirpy/internal/database"
 )
 
 type User struct {
-	ID       int    `json:"id"`
-	Email    string `json:"email"`
-	Password string `json:"-"`
+	ID            int    `json:"id"`
+	Email         string `json:"email"`
+	EmailVerified bool   `json:"email_verified"`
+	IsChirpyRed   bool   `json:"is_chirpy_red"`
+	Role          string `json:"role"`
+	Handle        string `json:"handle,omitempty"`
+	DisplayName   string `json:"display_name,omitempty"`
+	Bio           string `json:"bio,omitempty"`
+	AvatarURL     string `json:"avatar_url,omitempty"`
+	Password      string `json:"-"`
+
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+func userFromDB(user database.User) User {
+	role := user.Role
+	if role == "" {
+		role = auth.RoleUser
+	}
+
+	return User{
+		ID:            user.ID,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		IsChirpyRed:   user.IsChirpyRed,
+		Role:          role,
+		Handle:        user.Handle,
+		DisplayName:   user.DisplayName,
+		Bio:           user.Bio,
+		AvatarURL:     user.AvatarURL,
+
+		DeletionScheduledAt: user.DeletionScheduledAt,
+	}
 }
 
 func (cfg *apiConfig) handleUsersCreate(writer http.ResponseWriter, request *http.Request) {
@@ -27,85 +59,228 @@ func (cfg *apiConfig) handleUsersCreate(writer http.ResponseWriter, request *htt
 	params := parameters{}
 	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't decode parameters")
+		respondWithDecodeError(writer, request, err)
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	params.Email = database.NormalizeEmail(params.Email)
+	err = errors.Join(validateEmail(params.Email), cfg.validatePassword(params.Password))
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't hash password")
+		respondWithValidationError(writer, request, err)
 		return
 	}
 
-	user, err := cfg.DB.CreateUser(params.Email, hashedPassword)
+	hashedPassword, err := auth.HashPassword(request.Context(), params.Password)
+	if err != nil {
+		respondWithInternalError(writer, request, "Couldn't hash password", err)
+		return
+	}
+
+	user, err := cfg.DB.CreateUser(request.Context(), params.Email, hashedPassword)
 	if err != nil {
 		if errors.Is(err, database.ErrAlreadyExists) {
-			respondWithError(writer, http.StatusConflict, "User already exists")
+			respondWithError(writer, request, problemEmailTaken, "User already exists")
 			return
 		}
 
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't create user")
+		respondWithInternalError(writer, request, "Couldn't create user", err)
 		return
 	}
 
-	respondWithJson(writer, http.StatusCreated, User{
-		ID:    user.ID,
-		Email: user.Email})
-}
+	cfg.sendVerificationEmail(request, user)
 
-func (cfg *apiConfig) handleUsersUpdate(writer http.ResponseWriter, request *http.Request) {
+	respondWithJson(writer, http.StatusCreated, userFromDB(user))
+}
 
+// handleUsersPatch updates any subset of the caller's fields. Changing the
+// email or password requires the current password, so a stolen access token
+// isn't enough to take over the account. It also serves PUT /api/users, so
+// older clients that send only email and password through that route now get
+// a 401 until they include current_password too.
+func (cfg *apiConfig) handleUsersPatch(writer http.ResponseWriter, request *http.Request) {
 	type parameters struct {
-		Email    string `json:"email"`
-		Password string `json:"password"`
+		Email           *string `json:"email"`
+		Password        *string `json:"password"`
+		CurrentPassword string  `json:"current_password"`
+
+		Handle      *string `json:"handle"`
+		DisplayName *string `json:"display_name"`
+		Bio         *string `json:"bio"`
+		AvatarURL   *string `json:"avatar_url"`
 	}
 
 	type response struct {
 		User
+		// Set when the password changed, since every other session is
+		// signed out.
+		*sessionTokens
 	}
 
-	token, err := auth.GetBearerToken(request.Header)
-	if err != nil {
-		respondWithError(writer, http.StatusUnauthorized, "Couldn't find JWT")
-		return
-	}
-
-	subject, err := auth.ValidateJWT(token, cfg.jwtSecret)
-	if err != nil {
-		respondWithError(writer, http.StatusUnauthorized, "Couldn't validate JWT")
+	caller, ok := cfg.authorize(writer, request, auth.ScopeUsersWrite)
+	if !ok {
 		return
 	}
 
 	decoder := json.NewDecoder(request.Body)
 	params := parameters{}
-	err = decoder.Decode(&params)
+	err := decoder.Decode(&params)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't decode parameters")
+		respondWithDecodeError(writer, request, err)
 		return
 	}
 
-	hashedPassword, err := auth.HashPassword(params.Password)
+	user, err := cfg.DB.GetUser(request.Context(), caller.UserID)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't hash password")
+		respondWithInternalError(writer, request, "Couldn't get user", err)
 		return
 	}
 
-	userIDInt, err := strconv.Atoi(subject)
+	if params.Email != nil {
+		email := database.NormalizeEmail(*params.Email)
+		params.Email = &email
+	}
+
+	if params.Email != nil || params.Password != nil {
+		if !cfg.reauthenticate(writer, request, user, params.CurrentPassword) {
+			return
+		}
+	}
+
+	profile := user.Profile
+	if params.Handle != nil {
+		profile.Handle = strings.TrimSpace(*params.Handle)
+	}
+	if params.DisplayName != nil {
+		profile.DisplayName = strings.TrimSpace(*params.DisplayName)
+	}
+	if params.Bio != nil {
+		profile.Bio = strings.TrimSpace(*params.Bio)
+	}
+	if params.AvatarURL != nil {
+		profile.AvatarURL = strings.TrimSpace(*params.AvatarURL)
+	}
+	profileChanged := profile != user.Profile
+
+	errs := []error{}
+	if params.Email != nil {
+		errs = append(errs, validateEmail(*params.Email))
+	}
+	errs = append(errs, validateProfile(profile))
+	if params.Password != nil {
+		errs = append(errs, cfg.validatePassword(*params.Password))
+	}
+	err = errors.Join(errs...)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't parse user ID")
+		respondWithValidationError(writer, request, err)
 		return
 	}
 
-	user, err := cfg.DB.UpdateUser(userIDInt, params.Email, hashedPassword)
+	var hashedPassword string
+	if params.Password != nil {
+		hashedPassword, err = auth.HashPassword(request.Context(), *params.Password)
+		if err != nil {
+			respondWithInternalError(writer, request, "Couldn't hash password", err)
+			return
+		}
+	}
+
+	update := database.AccountUpdate{}
+	if profileChanged {
+		update.Profile = &profile
+	}
+	emailChanged := params.Email != nil && *params.Email != user.Email
+	if emailChanged {
+		update.Email = params.Email
+	}
+	if params.Password != nil {
+		update.HashedPassword = &hashedPassword
+	}
+
+	user, err = cfg.DB.UpdateAccount(request.Context(), user.ID, update)
 	if err != nil {
-		respondWithError(writer, http.StatusInternalServerError, "Couldn't create user")
+		if errors.Is(err, database.ErrEmailTaken) {
+			respondWithError(writer, request, problemEmailTaken, "Email is already in use")
+			return
+		}
+		if errors.Is(err, database.ErrHandleTaken) {
+			respondWithError(writer, request, problemHandleTaken, "Handle is already taken")
+			return
+		}
+
+		respondWithInternalError(writer, request, "Couldn't update user", err)
 		return
 	}
 
+	if emailChanged {
+		cfg.sendVerificationEmail(request, user)
+	}
+
+	var tokens *sessionTokens
+	if params.Password != nil {
+		err = cfg.DB.RevokeUserRefreshTokens(request.Context(), user.ID)
+		if err != nil {
+			respondWithInternalError(writer, request, "Couldn't revoke sessions", err)
+			return
+		}
+
+		// Keep the caller signed in with a fresh session.
+		newTokens, err := cfg.issueTokens(request.Context(), user, 0, 0)
+		if err != nil {
+			respondWithInternalError(writer, request, "Couldn't create tokens", err)
+			return
+		}
+		tokens = &newTokens
+	}
+
 	respondWithJson(writer, http.StatusOK, response{
-		User: User{
-			ID:    user.ID,
-			Email: user.Email,
-		},
+		User:          userFromDB(user),
+		sessionTokens: tokens,
 	})
 }
+
+// reauthenticate checks the current password before a sensitive change to
+// the account, responding with an error and returning false if it is wrong.
+func (cfg *apiConfig) reauthenticate(writer http.ResponseWriter, request *http.Request, user database.User, currentPassword string) bool {
+	if !cfg.checkLoginLockout(writer, request, user.Email) {
+		return false
+	}
+
+	if user.HashedPassword == "" {
+		cfg.releaseLoginAttempt(request, user.Email)
+		respondWithError(writer, request, problemPasswordNotSet, "Set a password with a password reset before making this change")
+		return false
+	}
+
+	_, err := auth.CheckPasswordHash(request.Context(), currentPassword, user.HashedPassword)
+	if err != nil {
+		cfg.recordLoginFailure()
+		respondWithError(writer, request, problemInvalidCredentials, "Current password is incorrect")
+		return false
+	}
+
+	cfg.releaseLoginAttempt(request, user.Email)
+	return true
+}
+
+func isValidEmail(email string) bool {
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Address == email
+}
+
+func validateEmail(email string) error {
+	if !isValidEmail(email) {
+		return fieldError{Field: "email", Code: "invalid", Message: "Invalid email address"}
+	}
+	return nil
+}
+
+// validatePassword checks password against the policy, returning a fieldError
+// whose code names the broken rule so the client can show a helpful message.
+func (cfg *apiConfig) validatePassword(password string) error {
+	err := cfg.passwordPolicy.Validate(password)
+	policyErr := &auth.PasswordPolicyError{}
+	if errors.As(err, &policyErr) {
+		return fieldError{Field: "password", Code: policyErr.Rule, Message: policyErr.Message}
+	}
+	return err
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"errors"
 	"fmt"
@@ -10,59 +11,154 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
-	"golang.org/x/crypto/bcrypt"
 )
 
 var ErrorNoAuthHeaderIncluded = errors.New("no auth header included in request")
 
-func HashPassword(password string) (string, error) {
-	dat, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+const (
+	issuerAccess = "chirpy"
+	issuerMFA    = "chirpy-mfa"
+)
+
+// Purposes of single-use tokens sent by email.
+const (
+	PurposeEmailVerification = "email-verification"
+	PurposePasswordReset     = "password-reset"
+)
+
+// Claims are the claims carried by tokens Chirpy signs. Role is only set on
+// access tokens from a user's own login, ClientID and Scope only on access
+// tokens issued to third-party OAuth clients, and Email only on single-use
+// tokens sent by email.
+type Claims struct {
+	jwt.RegisteredClaims
+	Role     string `json:"role,omitempty"`
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	Email    string `json:"email,omitempty"`
+}
+
+// MakeJWT issues an access token for a user's own session, carrying their
+// role so it can be checked without a database lookup.
+func MakeJWT(userID int, role, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeJWT(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:  issuerAccess,
+			Subject: fmt.Sprintf("%d", userID),
+		},
+		Role: role,
+	}, tokenSecret, expiresIn)
+}
+
+// MakeClientJWT issues an access token on behalf of a user to an OAuth
+// client, limited to the space-separated scopes granted to it.
+func MakeClientJWT(userID int, clientID, scope, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeJWT(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:  issuerAccess,
+			Subject: fmt.Sprintf("%d", userID),
+		},
+		ClientID: clientID,
+		Scope:    scope,
+	}, tokenSecret, expiresIn)
+}
+
+func ValidateJWT(tokenString, tokenSecret string) (string, error) {
+	claims, err := ParseJWT(tokenString, tokenSecret)
+	if err != nil {
+		return "", err
+	}
+	return claims.Subject, nil
+}
+
+// ParseJWT validates an access token and returns all of its claims.
+func ParseJWT(tokenString, tokenSecret string) (Claims, error) {
+	return parseJWT(tokenString, issuerAccess, tokenSecret)
+}
 
+// MakeMFAToken issues the short-lived challenge token handed out after a
+// correct password for an account with two-factor authentication enabled.
+// It is signed with a different issuer so it can't be used as an access token.
+func MakeMFAToken(userID int, tokenSecret string, expiresIn time.Duration) (string, error) {
+	return makeJWT(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:  issuerMFA,
+			Subject: fmt.Sprintf("%d", userID),
+		},
+	}, tokenSecret, expiresIn)
+}
+
+func ValidateMFAToken(tokenString, tokenSecret string) (string, error) {
+	claims, err := parseJWT(tokenString, issuerMFA, tokenSecret)
 	if err != nil {
 		return "", err
 	}
-	return string(dat), nil
+	return claims.Subject, nil
 }
 
-func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+// MakeSingleUseToken issues a token for a link sent to email, bound to the
+// address it was sent to. Its ID must be recorded when it is used so it
+// can't be used again.
+func MakeSingleUseToken(userID int, email, purpose, tokenSecret string, expiresIn time.Duration) (string, error) {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+
+	return makeJWT(Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:  "chirpy-" + purpose,
+			Subject: fmt.Sprintf("%d", userID),
+			ID:      hex.EncodeToString(id),
+		},
+		Email: email,
+	}, tokenSecret, expiresIn)
 }
 
-func MakeJWT(userID int, tokenSecret string, expiresIn time.Duration) (string, error) {
+func ParseSingleUseToken(tokenString, purpose, tokenSecret string) (Claims, error) {
+	claims, err := parseJWT(tokenString, "chirpy-"+purpose, tokenSecret)
+	if err != nil {
+		return Claims{}, err
+	}
+	if claims.ID == "" || claims.ExpiresAt == nil {
+		return Claims{}, errors.New("single-use token is missing its ID or expiry")
+	}
+	return claims, nil
+}
+
+func makeJWT(claims Claims, tokenSecret string, expiresIn time.Duration) (string, error) {
 	signingKey := []byte(tokenSecret)
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(expiresIn)),
-		Subject:   fmt.Sprintf("%d", userID),
-	})
+	claims.IssuedAt = jwt.NewNumericDate(time.Now().UTC())
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().UTC().Add(expiresIn))
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return token.SignedString(signingKey)
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (string, error) {
-	claimsStruct := jwt.RegisteredClaims{}
+func parseJWT(tokenString, expectedIssuer, tokenSecret string) (Claims, error) {
+	claimsStruct := Claims{}
 
 	token, err := jwt.ParseWithClaims(tokenString, &claimsStruct, func(token *jwt.Token) (interface{}, error) { return []byte(tokenSecret), nil })
 	if err != nil {
-		return "", err
+		return Claims{}, err
 	}
 
-	userIDString, err := token.Claims.GetSubject()
-	if err != nil {
-		return "", err
+	if _, err := token.Claims.GetSubject(); err != nil {
+		return Claims{}, err
 	}
 
 	issuer, err := token.Claims.GetIssuer()
 
 	if err != nil {
-		return "", err
+		return Claims{}, err
 	}
-	if issuer != string("chirpy") {
-		return "", errors.New("invalid issuer")
+	if issuer != expectedIssuer {
+		return Claims{}, errors.New("invalid issuer")
 	}
 
-	return userIDString, nil
+	return claimsStruct, nil
 }
 
 func GetBearerToken(headers http.Header) (string, error) {
@@ -79,6 +175,22 @@ func GetBearerToken(headers http.Header) (string, error) {
 	return splitAuth[1], nil
 }
 
+// GetAPIKey returns the key from an "Authorization: ApiKey <key>" header, as
+// sent by services calling our webhooks.
+func GetAPIKey(headers http.Header) (string, error) {
+	authHeader := headers.Get("Authorization")
+	if authHeader == "" {
+		return "", ErrorNoAuthHeaderIncluded
+	}
+
+	splitAuth := strings.Split(authHeader, " ")
+	if len(splitAuth) < 2 || splitAuth[0] != "ApiKey" {
+		return "", errors.New("malformed authorization header")
+	}
+
+	return splitAuth[1], nil
+}
+
 func MakeRefreshToken() (string, error) {
 	token := make([]byte, 32)
 	_, err := rand.Read(token)
@@ -88,3 +200,20 @@ func MakeRefreshToken() (string, error) {
 
 	return hex.EncodeToString(token), nil
 }
+
+// IsRefreshToken reports whether token has the shape of one made by
+// MakeRefreshToken. It doesn't check that the token was ever issued.
+func IsRefreshToken(token string) bool {
+	if len(token) != 64 {
+		return false
+	}
+	_, err := hex.DecodeString(token)
+	return err == nil
+}
+
+// HashToken hashes a high-entropy secret such as a recovery code for storage.
+// Unlike passwords these don't need a slow hash.
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
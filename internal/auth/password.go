@@ -0,0 +1,184 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var tracer = otel.Tracer("github.com/keertirajmalik/chirpy/internal/auth")
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	ErrPasswordMismatch   = errors.New("password doesn't match hash")
+	ErrUnknownHashFormat  = errors.New("unknown password hash format")
+	ErrInvalidHashParams  = errors.New("invalid password hash parameters")
+	errMalformedArgonHash = errors.New("malformed argon2id hash")
+)
+
+// PasswordHashParams controls how new password hashes are created. Hashes
+// are stored in the self-describing PHC ($argon2id$...) or modular crypt
+// ($2a$...) formats, so hashes made with older parameters can still be
+// checked and are flagged for rehashing.
+type PasswordHashParams struct {
+	Algorithm string
+
+	BcryptCost int
+
+	// Argon2Memory is in KiB.
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+}
+
+// DefaultPasswordHashParams follow the OWASP recommendation for argon2id.
+var DefaultPasswordHashParams = PasswordHashParams{
+	Algorithm:         AlgorithmArgon2id,
+	BcryptCost:        bcrypt.DefaultCost,
+	Argon2Memory:      19 * 1024,
+	Argon2Iterations:  2,
+	Argon2Parallelism: 1,
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var hashParams = DefaultPasswordHashParams
+
+// SetPasswordHashParams changes the parameters used by HashPassword. It
+// should be called once at startup.
+func SetPasswordHashParams(params PasswordHashParams) error {
+	switch params.Algorithm {
+	case AlgorithmBcrypt:
+		if params.BcryptCost < bcrypt.MinCost || params.BcryptCost > bcrypt.MaxCost {
+			return fmt.Errorf("%w: bcrypt cost must be between %d and %d", ErrInvalidHashParams, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+	case AlgorithmArgon2id:
+		if params.Argon2Memory < 8*uint32(params.Argon2Parallelism) || params.Argon2Iterations < 1 || params.Argon2Parallelism < 1 {
+			return fmt.Errorf("%w: argon2id needs at least 1 iteration, 1 thread and 8 KiB of memory per thread", ErrInvalidHashParams)
+		}
+	default:
+		return fmt.Errorf("%w: unknown algorithm %q", ErrInvalidHashParams, params.Algorithm)
+	}
+
+	hashParams = params
+	return nil
+}
+
+func HashPassword(ctx context.Context, password string) (string, error) {
+	_, span := tracer.Start(ctx, "auth.HashPassword", trace.WithAttributes(
+		attribute.String("auth.hash_algorithm", hashParams.Algorithm),
+	))
+	defer span.End()
+
+	if hashParams.Algorithm == AlgorithmBcrypt {
+		dat, err := bcrypt.GenerateFromPassword([]byte(password), hashParams.BcryptCost)
+
+		if err != nil {
+			return "", err
+		}
+		return string(dat), nil
+	}
+
+	salt := make([]byte, argon2SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, hashParams.Argon2Iterations, hashParams.Argon2Memory, hashParams.Argon2Parallelism, argon2KeyLength)
+
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		hashParams.Argon2Memory,
+		hashParams.Argon2Iterations,
+		hashParams.Argon2Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+// CheckPasswordHash reports whether password matches hash. If it does,
+// needsRehash says whether the hash was made with an algorithm or parameters
+// other than the current ones and should be replaced.
+func CheckPasswordHash(ctx context.Context, password, hash string) (needsRehash bool, err error) {
+	_, span := tracer.Start(ctx, "auth.CheckPasswordHash")
+	defer span.End()
+
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return checkArgon2idHash(password, hash)
+	case strings.HasPrefix(hash, "$2"):
+		err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+		if err != nil {
+			return false, ErrPasswordMismatch
+		}
+
+		cost, err := bcrypt.Cost([]byte(hash))
+		if err != nil {
+			return false, err
+		}
+		return hashParams.Algorithm != AlgorithmBcrypt || cost != hashParams.BcryptCost, nil
+	default:
+		return false, ErrUnknownHashFormat
+	}
+}
+
+func checkArgon2idHash(password, hash string) (bool, error) {
+	// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, errMalformedArgonHash
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return false, errMalformedArgonHash
+	}
+
+	var memory, iterations uint32
+	var parallelism uint8
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &iterations, &parallelism)
+	if err != nil {
+		return false, errMalformedArgonHash
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, errMalformedArgonHash
+	}
+
+	expected, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, errMalformedArgonHash
+	}
+
+	key := argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, uint32(len(expected)))
+	if subtle.ConstantTimeCompare(key, expected) != 1 {
+		return false, ErrPasswordMismatch
+	}
+
+	needsRehash := hashParams.Algorithm != AlgorithmArgon2id ||
+		memory != hashParams.Argon2Memory ||
+		iterations != hashParams.Argon2Iterations ||
+		parallelism != hashParams.Argon2Parallelism ||
+		len(expected) != argon2KeyLength
+	return needsRehash, nil
+}
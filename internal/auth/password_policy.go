@@ -0,0 +1,152 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// BcryptMaxBytes is the longest password bcrypt takes into account; anything
+// after it is silently ignored.
+const BcryptMaxBytes = 72
+
+const (
+	PasswordRuleMinLength = "min_length"
+	PasswordRuleMaxBytes  = "max_bytes"
+	PasswordRuleBreached  = "breached"
+)
+
+// PasswordPolicyError names the rule a password broke.
+type PasswordPolicyError struct {
+	Rule    string
+	Message string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return e.Message
+}
+
+type PasswordPolicy struct {
+	MinLength int
+	MaxBytes  int
+	// Breached is optional; when set, passwords found in it are rejected.
+	Breached *BreachedPasswords
+}
+
+// Validate returns a *PasswordPolicyError if password breaks the policy.
+func (p PasswordPolicy) Validate(password string) error {
+	if utf8.RuneCountInString(password) < p.MinLength {
+		return &PasswordPolicyError{
+			Rule:    PasswordRuleMinLength,
+			Message: fmt.Sprintf("Password must be at least %d characters long", p.MinLength),
+		}
+	}
+
+	if len(password) > p.MaxBytes {
+		return &PasswordPolicyError{
+			Rule:    PasswordRuleMaxBytes,
+			Message: fmt.Sprintf("Password must be at most %d bytes long", p.MaxBytes),
+		}
+	}
+
+	if p.Breached != nil {
+		breached, err := p.Breached.Contains(password)
+		if err != nil {
+			return err
+		}
+		if breached {
+			return &PasswordPolicyError{
+				Rule:    PasswordRuleBreached,
+				Message: "Password has appeared in a data breach, choose a different one",
+			}
+		}
+	}
+
+	return nil
+}
+
+// BreachedPasswords is a local copy of the Pwned Passwords list, keyed by
+// SHA-1 hash. It is either a directory of range files named after the first
+// five hex characters of the hash, each holding "SUFFIX:COUNT" lines as
+// returned by the k-anonymity range API, or a single file of "HASH:COUNT"
+// lines which is loaded into memory.
+type BreachedPasswords struct {
+	dir    string
+	ranges map[string]map[string]struct{}
+}
+
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &BreachedPasswords{dir: path}, nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	ranges := map[string]map[string]struct{}{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		hash, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if len(hash) != sha1.Size*2 {
+			continue
+		}
+		hash = strings.ToUpper(hash)
+
+		prefix, suffix := hash[:5], hash[5:]
+		if ranges[prefix] == nil {
+			ranges[prefix] = map[string]struct{}{}
+		}
+		ranges[prefix][suffix] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return &BreachedPasswords{ranges: ranges}, nil
+}
+
+func (b *BreachedPasswords) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := hash[:5], hash[5:]
+
+	if b.dir == "" {
+		_, ok := b.ranges[prefix][suffix]
+		return ok, nil
+	}
+
+	file, err := os.Open(filepath.Join(b.dir, prefix))
+	if errors.Is(err, os.ErrNotExist) {
+		file, err = os.Open(filepath.Join(b.dir, prefix+".txt"))
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		candidate, _, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(candidate, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
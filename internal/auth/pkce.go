@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+)
+
+const PKCEMethodS256 = "S256"
+
+// VerifyPKCE checks a code_verifier against the code_challenge sent with the
+// authorization request (RFC 7636). Only the S256 method is supported.
+func VerifyPKCE(verifier, challenge, method string) bool {
+	if method != PKCEMethodS256 {
+		return false
+	}
+
+	// RFC 7636 requires verifiers of 43 to 128 characters.
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return false
+	}
+
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -0,0 +1,36 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+)
+
+// TestVerifyPKCE uses the example from RFC 7636 Appendix B.
+func TestVerifyPKCE(t *testing.T) {
+	const (
+		verifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+		challenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	)
+
+	tests := []struct {
+		name      string
+		verifier  string
+		challenge string
+		method    string
+		want      bool
+	}{
+		{name: "rfc example", verifier: verifier, challenge: challenge, method: auth.PKCEMethodS256, want: true},
+		{name: "wrong verifier", verifier: verifier[:42] + "Y", challenge: challenge, method: auth.PKCEMethodS256},
+		{name: "plain method", verifier: verifier, challenge: verifier, method: "plain"},
+		{name: "short verifier", verifier: verifier[:42], challenge: challenge, method: auth.PKCEMethodS256},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := auth.VerifyPKCE(tt.verifier, tt.challenge, tt.method)
+			if got != tt.want {
+				t.Errorf("VerifyPKCE() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,26 @@
+package auth
+
+// Roles a user can hold, from least to most privileged. Each role can do
+// everything the ones before it can.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+var roleRank = map[string]int{RoleUser: 0, RoleModerator: 1, RoleAdmin: 2}
+
+func IsValidRole(role string) bool {
+	_, ok := roleRank[role]
+	return ok
+}
+
+// HasRole reports whether role grants at least the privileges of required.
+// An empty role is treated as RoleUser.
+func HasRole(role, required string) bool {
+	if role == "" {
+		role = RoleUser
+	}
+	rank, ok := roleRank[role]
+	return ok && rank >= roleRank[required]
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	ScopeChirpsRead  = "chirps:read"
+	ScopeChirpsWrite = "chirps:write"
+	ScopeUsersWrite  = "users:write"
+)
+
+// AllScopes lists every scope a token can be granted. A user's own session
+// implicitly holds all of them.
+var AllScopes = []string{ScopeChirpsRead, ScopeChirpsWrite, ScopeUsersWrite}
+
+const apiTokenPrefix = "chirpy_pat_"
+
+// MakeAPIToken generates a personal access token. The prefix lets the server
+// tell it apart from a JWT and makes leaked tokens easy to search for.
+func MakeAPIToken() (string, error) {
+	token := make([]byte, 32)
+	_, err := rand.Read(token)
+	if err != nil {
+		return "", err
+	}
+
+	return apiTokenPrefix + hex.EncodeToString(token), nil
+}
+
+func IsAPIToken(token string) bool {
+	return strings.HasPrefix(token, apiTokenPrefix)
+}
+
+func IsValidScope(scope string) bool {
+	for _, known := range AllScopes {
+		if scope == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package auth_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+)
+
+// TestValidateTOTP checks the SHA-1 test vectors from RFC 6238 Appendix B.
+// The RFC gives 8-digit codes; ours are their last 6 digits.
+func TestValidateTOTP(t *testing.T) {
+	// Base32 of the ASCII secret "12345678901234567890".
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1111111111, code: "050471"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+		{unix: 20000000000, code: "353130"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code, func(t *testing.T) {
+			step, err := auth.ValidateTOTP(tt.code, secret, time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatalf("ValidateTOTP() error = %v", err)
+			}
+			if want := tt.unix / 30; step != want {
+				t.Errorf("ValidateTOTP() step = %d, want %d", step, want)
+			}
+		})
+	}
+
+	_, err := auth.ValidateTOTP("000000", secret, time.Unix(59, 0))
+	if !errors.Is(err, auth.ErrInvalidTOTPCode) {
+		t.Errorf("ValidateTOTP() with a wrong code: error = %v, want %v", err, auth.ErrInvalidTOTPCode)
+	}
+}
@@ -0,0 +1,441 @@
+// Package config loads the server's settings. Each setting has a default
+// that can be overridden by a YAML file, then by an environment variable and
+// finally by a command-line flag.
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/mail"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+)
+
+// Config is grouped into sections that match the YAML file. The env tag
+// names the environment variable for a setting, and its flag is named after
+// its key in the file, such as -server.port. Settings tagged secret are
+// redacted when the config is printed.
+type Config struct {
+	Server   Server   `yaml:"server"`
+	Database Database `yaml:"database"`
+	Log      Log      `yaml:"log"`
+	Auth     Auth     `yaml:"auth"`
+	Password Password `yaml:"password"`
+	Lockout  Lockout  `yaml:"lockout"`
+	OIDC     OIDC     `yaml:"oidc"`
+	Mail     Mail     `yaml:"mail"`
+	Chirps   Chirps   `yaml:"chirps"`
+	Accounts Accounts `yaml:"accounts"`
+	Polka    Polka    `yaml:"polka"`
+	Metrics  Metrics  `yaml:"metrics"`
+	Tracing  Tracing  `yaml:"tracing"`
+}
+
+type Server struct {
+	Port      int    `yaml:"port" env:"PORT" help:"Port to listen on"`
+	PublicURL string `yaml:"public_url" env:"PUBLIC_URL" help:"URL the server is reached at, used for links in emails (default http://localhost:<port>)"`
+
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout" env:"SERVER_READ_HEADER_TIMEOUT" help:"How long clients have to send request headers"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout" env:"SERVER_IDLE_TIMEOUT" help:"How long idle keep-alive connections are kept open"`
+	ShutdownTimeout   time.Duration `yaml:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT" help:"How long to wait for in-flight requests and background work when shutting down"`
+}
+
+type Database struct {
+	Path string `yaml:"path" env:"DATABASE_PATH" help:"Path to the JSON database file"`
+}
+
+type Log struct {
+	Format string `yaml:"format" env:"LOG_FORMAT" help:"Log format, text or json"`
+	Level  string `yaml:"level" env:"LOG_LEVEL" help:"Minimum log level, debug, info, warn or error"`
+}
+
+type Auth struct {
+	JWTSecret          string        `yaml:"jwt_secret" env:"JWT_SECRET" secret:"true" help:"Key used to sign access tokens"`
+	AccessTokenTTL     time.Duration `yaml:"access_token_ttl" env:"ACCESS_TOKEN_TTL" help:"Default lifetime of access tokens"`
+	AccessTokenMaxTTL  time.Duration `yaml:"access_token_max_ttl" env:"ACCESS_TOKEN_MAX_TTL" help:"Longest lifetime a client can ask for an access token"`
+	RefreshTokenTTL    time.Duration `yaml:"refresh_token_ttl" env:"REFRESH_TOKEN_TTL" help:"Default lifetime of refresh tokens"`
+	RefreshTokenMaxTTL time.Duration `yaml:"refresh_token_max_ttl" env:"REFRESH_TOKEN_MAX_TTL" help:"Longest lifetime a client can ask for a refresh token"`
+}
+
+type Password struct {
+	MinLength         int    `yaml:"min_length" env:"PASSWORD_MIN_LENGTH" help:"Shortest password allowed, in characters"`
+	MaxBytes          int    `yaml:"max_bytes" env:"PASSWORD_MAX_BYTES" help:"Longest password allowed, in bytes"`
+	BreachedPath      string `yaml:"breached_passwords_path" env:"BREACHED_PASSWORDS_PATH" help:"File of breached passwords to reject, one per line"`
+	HashAlgorithm     string `yaml:"hash_algorithm" env:"PASSWORD_HASH_ALGORITHM" help:"Algorithm for new password hashes, argon2id or bcrypt"`
+	BcryptCost        int    `yaml:"bcrypt_cost" env:"BCRYPT_COST" help:"bcrypt cost factor"`
+	Argon2MemoryKiB   int    `yaml:"argon2_memory_kib" env:"ARGON2_MEMORY_KIB" help:"argon2id memory, in KiB"`
+	Argon2Iterations  int    `yaml:"argon2_iterations" env:"ARGON2_ITERATIONS" help:"argon2id iterations"`
+	Argon2Parallelism int    `yaml:"argon2_parallelism" env:"ARGON2_PARALLELISM" help:"argon2id threads"`
+}
+
+type Lockout struct {
+	AccountThreshold int           `yaml:"account_threshold" env:"LOCKOUT_ACCOUNT_THRESHOLD" help:"Failed logins allowed per account before it is locked"`
+	IPThreshold      int           `yaml:"ip_threshold" env:"LOCKOUT_IP_THRESHOLD" help:"Failed logins allowed per IP address before it is locked"`
+	BaseDelay        time.Duration `yaml:"base_delay" env:"LOCKOUT_BASE_DELAY" help:"How long the first lockout lasts, doubling with each further failure"`
+	MaxDelay         time.Duration `yaml:"max_delay" env:"LOCKOUT_MAX_DELAY" help:"Longest a lockout can last"`
+	ResetAfter       time.Duration `yaml:"reset_after" env:"LOCKOUT_RESET_AFTER" help:"Forget failed logins after this long without one"`
+}
+
+type OIDC struct {
+	Issuer       string `yaml:"issuer" env:"OIDC_ISSUER" help:"OpenID Connect issuer URL, leave empty to turn off single sign-on"`
+	ClientID     string `yaml:"client_id" env:"OIDC_CLIENT_ID" help:"OpenID Connect client ID"`
+	ClientSecret string `yaml:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true" help:"OpenID Connect client secret"`
+	RedirectURL  string `yaml:"redirect_url" env:"OIDC_REDIRECT_URL" help:"OpenID Connect redirect URL"`
+}
+
+type Mail struct {
+	Mailer       string `yaml:"mailer" env:"MAILER" help:"How to send email, smtp, file or log (which leaves out message bodies)"`
+	From         string `yaml:"from" env:"MAIL_FROM" help:"Sender of emails"`
+	SMTPAddr     string `yaml:"smtp_addr" env:"SMTP_ADDR" help:"SMTP server address"`
+	SMTPUsername string `yaml:"smtp_username" env:"SMTP_USERNAME" help:"SMTP username"`
+	SMTPPassword string `yaml:"smtp_password" env:"SMTP_PASSWORD" secret:"true" help:"SMTP password"`
+	File         string `yaml:"file" env:"MAIL_FILE" help:"File emails are appended to by the file mailer"`
+}
+
+type Chirps struct {
+	MaxLength    int      `yaml:"max_length" env:"CHIRP_MAX_LENGTH" help:"Longest chirp allowed"`
+	RedMaxLength int      `yaml:"red_max_length" env:"CHIRP_RED_MAX_LENGTH" help:"Longest chirp allowed for Chirpy Red members"`
+	BadWords     []string `yaml:"bad_words" env:"CHIRP_BAD_WORDS" help:"Comma-separated words censored in chirps"`
+}
+
+type Accounts struct {
+	DeletionGracePeriod time.Duration `yaml:"deletion_grace_period" env:"ACCOUNT_DELETION_GRACE_PERIOD" help:"How long deleted accounts can be restored"`
+	PurgeInterval       time.Duration `yaml:"purge_interval" env:"ACCOUNT_PURGE_INTERVAL" help:"How often deleted accounts are purged"`
+}
+
+type Polka struct {
+	Key string `yaml:"key" env:"POLKA_KEY" secret:"true" help:"API key Polka webhooks are signed with"`
+}
+
+type Metrics struct {
+	Token string `yaml:"token" env:"METRICS_TOKEN" secret:"true" help:"Bearer token required for /metrics, leave empty to allow only admins"`
+}
+
+type Tracing struct {
+	Exporter string `yaml:"exporter" env:"OTEL_TRACES_EXPORTER" help:"Where to send traces, otlp, stdout or none"`
+}
+
+// Default returns the configuration used when nothing is overridden.
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port: 8080,
+
+			ReadHeaderTimeout: 10 * time.Second,
+			IdleTimeout:       2 * time.Minute,
+			ShutdownTimeout:   30 * time.Second,
+		},
+		Database: Database{
+			Path: "database.json",
+		},
+		Log: Log{
+			Format: "text",
+			Level:  "info",
+		},
+		Auth: Auth{
+			AccessTokenTTL:     time.Hour,
+			AccessTokenMaxTTL:  24 * time.Hour,
+			RefreshTokenTTL:    60 * 24 * time.Hour,
+			RefreshTokenMaxTTL: 90 * 24 * time.Hour,
+		},
+		Password: Password{
+			MinLength:         8,
+			MaxBytes:          auth.BcryptMaxBytes,
+			HashAlgorithm:     auth.DefaultPasswordHashParams.Algorithm,
+			BcryptCost:        auth.DefaultPasswordHashParams.BcryptCost,
+			Argon2MemoryKiB:   int(auth.DefaultPasswordHashParams.Argon2Memory),
+			Argon2Iterations:  int(auth.DefaultPasswordHashParams.Argon2Iterations),
+			Argon2Parallelism: int(auth.DefaultPasswordHashParams.Argon2Parallelism),
+		},
+		Lockout: Lockout{
+			AccountThreshold: 5,
+			IPThreshold:      20,
+			BaseDelay:        30 * time.Second,
+			MaxDelay:         15 * time.Minute,
+			ResetAfter:       time.Hour,
+		},
+		Mail: Mail{
+			Mailer:   "log",
+			From:     "Chirpy <no-reply@chirpy.local>",
+			SMTPAddr: "localhost:1025",
+			File:     "mail.log",
+		},
+		Chirps: Chirps{
+			MaxLength:    140,
+			RedMaxLength: 280,
+			BadWords:     []string{"kerfuffle", "sharbert", "fornax"},
+		},
+		Accounts: Accounts{
+			DeletionGracePeriod: 30 * 24 * time.Hour,
+			PurgeInterval:       time.Hour,
+		},
+		Tracing: Tracing{
+			Exporter: "none",
+		},
+	}
+}
+
+// Load reads the configuration, registering a flag for every setting on fs
+// along with -config, which names the YAML file to read and defaults to
+// CHIRPY_CONFIG. Arguments left after the flags are available from fs.Args.
+// The result should be checked with Validate.
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	path := fs.String("config", os.Getenv("CHIRPY_CONFIG"), "Path to a YAML configuration file (env CHIRPY_CONFIG)")
+
+	// Flags are parsed first to find the config file, but applied last so
+	// they win over it and the environment.
+	flagValues := map[string]reflect.Value{}
+	for _, s := range settings {
+		fs.Func(s.key, fmt.Sprintf("%s (env %s)", s.help, s.env), func(value string) error {
+			parsed, err := parse(s.value.Type(), value)
+			if err != nil {
+				return err
+			}
+			flagValues[s.key] = parsed
+			return nil
+		})
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		err := cfg.loadFile(*path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range settings {
+		value := os.Getenv(s.env)
+		if value == "" {
+			continue
+		}
+		parsed, err := parse(s.value.Type(), value)
+		if err != nil {
+			return nil, fmt.Errorf("environment variable %s: %w", s.env, err)
+		}
+		s.value.Set(parsed)
+	}
+
+	for _, s := range settings {
+		if parsed, ok := flagValues[s.key]; ok {
+			s.value.Set(parsed)
+		}
+	}
+
+	if cfg.Server.PublicURL == "" {
+		cfg.Server.PublicURL = fmt.Sprintf("http://localhost:%d", cfg.Server.Port)
+	}
+	cfg.Server.PublicURL = strings.TrimSuffix(cfg.Server.PublicURL, "/")
+
+	return cfg, nil
+}
+
+func (cfg *Config) loadFile(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("couldn't open config file: %w", err)
+	}
+	defer file.Close()
+
+	decoder := yaml.NewDecoder(file)
+	// Catch misspelt settings rather than silently ignoring them.
+	decoder.KnownFields(true)
+	err = decoder.Decode(cfg)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("couldn't read config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the settings make sense together. It reports every
+// problem at once so they can all be fixed before the next start.
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	for _, s := range cfg.settings() {
+		switch value := s.value.Interface().(type) {
+		case int:
+			check(value >= 0, "%s must not be negative", s)
+		case time.Duration:
+			check(value > 0, "%s must be a positive duration", s)
+		}
+	}
+
+	check(cfg.Server.Port > 0 && cfg.Server.Port <= 65535, "server.port (PORT) must be between 1 and 65535")
+	check(cfg.Database.Path != "", "database.path (DATABASE_PATH) must be set")
+
+	check(cfg.Log.Format == "text" || cfg.Log.Format == "json", "log.format (LOG_FORMAT) must be text or json, got %q", cfg.Log.Format)
+	var level slog.Level
+	check(level.UnmarshalText([]byte(cfg.Log.Level)) == nil, "log.level (LOG_LEVEL) must be debug, info, warn or error, got %q", cfg.Log.Level)
+
+	check(cfg.Auth.JWTSecret != "", "auth.jwt_secret (JWT_SECRET) must be set")
+	check(cfg.Auth.AccessTokenTTL <= cfg.Auth.AccessTokenMaxTTL, "auth.access_token_ttl (ACCESS_TOKEN_TTL) must not be greater than auth.access_token_max_ttl (ACCESS_TOKEN_MAX_TTL)")
+	check(cfg.Auth.RefreshTokenTTL <= cfg.Auth.RefreshTokenMaxTTL, "auth.refresh_token_ttl (REFRESH_TOKEN_TTL) must not be greater than auth.refresh_token_max_ttl (REFRESH_TOKEN_MAX_TTL)")
+
+	check(cfg.Password.MinLength <= cfg.Password.MaxBytes, "password.min_length (PASSWORD_MIN_LENGTH) must not be greater than password.max_bytes (PASSWORD_MAX_BYTES)")
+	check(cfg.Password.HashAlgorithm != auth.AlgorithmBcrypt || cfg.Password.MaxBytes <= auth.BcryptMaxBytes,
+		"password.max_bytes (PASSWORD_MAX_BYTES) can't be more than %d with bcrypt, it ignores anything longer", auth.BcryptMaxBytes)
+	check(cfg.Password.Argon2Parallelism <= 255, "password.argon2_parallelism (ARGON2_PARALLELISM) must be at most 255")
+
+	check(cfg.Lockout.AccountThreshold > 0, "lockout.account_threshold (LOCKOUT_ACCOUNT_THRESHOLD) must be at least 1")
+	check(cfg.Lockout.IPThreshold > 0, "lockout.ip_threshold (LOCKOUT_IP_THRESHOLD) must be at least 1")
+	check(cfg.Lockout.BaseDelay <= cfg.Lockout.MaxDelay, "lockout.base_delay (LOCKOUT_BASE_DELAY) must not be greater than lockout.max_delay (LOCKOUT_MAX_DELAY)")
+
+	if cfg.OIDC.Issuer != "" {
+		check(cfg.OIDC.ClientID != "" && cfg.OIDC.RedirectURL != "", "oidc.client_id (OIDC_CLIENT_ID) and oidc.redirect_url (OIDC_REDIRECT_URL) must be set when oidc.issuer (OIDC_ISSUER) is set")
+	}
+
+	_, err := mail.ParseAddress(cfg.Mail.From)
+	check(err == nil, "mail.from (MAIL_FROM) must be an address such as \"Chirpy <no-reply@example.com>\", got %q", cfg.Mail.From)
+
+	switch cfg.Mail.Mailer {
+	case "smtp", "file", "log":
+	default:
+		check(false, "mail.mailer (MAILER) must be smtp, file or log, got %q", cfg.Mail.Mailer)
+	}
+
+	check(cfg.Chirps.MaxLength > 0, "chirps.max_length (CHIRP_MAX_LENGTH) must be at least 1")
+	check(cfg.Chirps.RedMaxLength >= cfg.Chirps.MaxLength, "chirps.red_max_length (CHIRP_RED_MAX_LENGTH) must not be less than chirps.max_length (CHIRP_MAX_LENGTH)")
+
+	switch cfg.Tracing.Exporter {
+	case "otlp", "stdout", "none":
+	default:
+		check(false, "tracing.exporter (OTEL_TRACES_EXPORTER) must be otlp, stdout or none, got %q", cfg.Tracing.Exporter)
+	}
+
+	return errors.Join(errs...)
+}
+
+// Print writes the configuration as YAML, in the same format as the config
+// file, with secrets redacted.
+func (cfg *Config) Print(w io.Writer) error {
+	redacted := *cfg
+	for _, s := range redacted.settings() {
+		if s.secret && s.value.String() != "" {
+			s.value.SetString("REDACTED")
+		}
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	err := encoder.Encode(&redacted)
+	if err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// PasswordHashParams converts the password settings for
+// auth.SetPasswordHashParams.
+func (cfg *Config) PasswordHashParams() auth.PasswordHashParams {
+	return auth.PasswordHashParams{
+		Algorithm:         cfg.Password.HashAlgorithm,
+		BcryptCost:        cfg.Password.BcryptCost,
+		Argon2Memory:      uint32(cfg.Password.Argon2MemoryKiB),
+		Argon2Iterations:  uint32(cfg.Password.Argon2Iterations),
+		Argon2Parallelism: uint8(cfg.Password.Argon2Parallelism),
+	}
+}
+
+// MailFrom is the sender of emails, which Validate has checked is a valid
+// address.
+func (cfg *Config) MailFrom() mail.Address {
+	from, err := mail.ParseAddress(cfg.Mail.From)
+	if err != nil {
+		return mail.Address{}
+	}
+	return *from
+}
+
+// setting is one field of a section of Config.
+type setting struct {
+	key    string
+	env    string
+	help   string
+	secret bool
+	value  reflect.Value
+}
+
+// String names the setting in errors by both its key and its environment
+// variable, as either may be how it was set.
+func (s setting) String() string {
+	return fmt.Sprintf("%s (%s)", s.key, s.env)
+}
+
+func (cfg *Config) settings() []setting {
+	var settings []setting
+
+	sections := reflect.ValueOf(cfg).Elem()
+	for i := 0; i < sections.NumField(); i++ {
+		section := sections.Field(i)
+		sectionKey := sections.Type().Field(i).Tag.Get("yaml")
+
+		for j := 0; j < section.NumField(); j++ {
+			field := section.Type().Field(j)
+			settings = append(settings, setting{
+				key:    sectionKey + "." + field.Tag.Get("yaml"),
+				env:    field.Tag.Get("env"),
+				help:   field.Tag.Get("help"),
+				secret: field.Tag.Get("secret") == "true",
+				value:  section.Field(j),
+			})
+		}
+	}
+
+	return settings
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// parse converts a value from the environment or a flag to the type of a
+// setting.
+func parse(typ reflect.Type, value string) (reflect.Value, error) {
+	switch {
+	case typ == durationType:
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not a duration such as 15m or 720h", value)
+		}
+		return reflect.ValueOf(duration), nil
+	case typ.Kind() == reflect.Int:
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return reflect.Value{}, fmt.Errorf("%q is not an integer", value)
+		}
+		return reflect.ValueOf(number), nil
+	case typ.Kind() == reflect.String:
+		return reflect.ValueOf(value), nil
+	case typ.Kind() == reflect.Slice && typ.Elem().Kind() == reflect.String:
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			item = strings.TrimSpace(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+		return reflect.ValueOf(items), nil
+	default:
+		return reflect.Value{}, fmt.Errorf("unsupported setting type %s", typ)
+	}
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+type APIToken struct {
+	ID          int       `json:"id"`
+	UserID      int       `json:"user_id"`
+	Name        string    `json:"name"`
+	HashedToken string    `json:"hashed_token"`
+	Scopes      []string  `json:"scopes"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+func (db *DB) CreateAPIToken(ctx context.Context, userID int, name, hashedToken string, scopes []string) (APIToken, error) {
+	ctx, span := tracer.Start(ctx, "database.CreateAPIToken")
+	defer span.End()
+
+	apiToken := APIToken{}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		id := 1
+		for existingID := range dbStructure.APITokens {
+			if existingID >= id {
+				id = existingID + 1
+			}
+		}
+
+		apiToken = APIToken{
+			ID:          id,
+			UserID:      userID,
+			Name:        name,
+			HashedToken: hashedToken,
+			Scopes:      scopes,
+			CreatedAt:   time.Now().UTC(),
+		}
+		dbStructure.APITokens[id] = apiToken
+		return nil
+	})
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	return apiToken, nil
+}
+
+func (db *DB) GetAPITokens(ctx context.Context, userID int) ([]APIToken, error) {
+	ctx, span := tracer.Start(ctx, "database.GetAPITokens")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	apiTokens := []APIToken{}
+	for _, apiToken := range dbStructure.APITokens {
+		if apiToken.UserID == userID {
+			apiTokens = append(apiTokens, apiToken)
+		}
+	}
+	return apiTokens, nil
+}
+
+func (db *DB) GetAPITokenByHash(ctx context.Context, hashedToken string) (APIToken, error) {
+	ctx, span := tracer.Start(ctx, "database.GetAPITokenByHash")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
+	if err != nil {
+		return APIToken{}, err
+	}
+
+	for _, apiToken := range dbStructure.APITokens {
+		if apiToken.HashedToken == hashedToken {
+			return apiToken, nil
+		}
+	}
+
+	return APIToken{}, ErrNotExist
+}
+
+func (db *DB) RevokeAPIToken(ctx context.Context, id, userID int) error {
+	ctx, span := tracer.Start(ctx, "database.RevokeAPIToken")
+	defer span.End()
+
+	return db.update(ctx, func(dbStructure *DBStructure) error {
+		apiToken, ok := dbStructure.APITokens[id]
+		if !ok || apiToken.UserID != userID {
+			return ErrNotExist
+		}
+
+		delete(dbStructure.APITokens, id)
+		return nil
+	})
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"context"
+	"slices"
+)
+
+// A user can block or mute another. Muting only hides the other user's
+// chirps, while blocking also hides the blocker's chirps from them.
+const (
+	RelationshipBlock = "block"
+	RelationshipMute  = "mute"
+)
+
+// SetRelationship adds or removes a block or mute from userID on targetID.
+func (db *DB) SetRelationship(ctx context.Context, userID, targetID int, relationship string, enabled bool) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.SetRelationship")
+	defer span.End()
+
+	user := User{}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+		if _, ok := dbStructure.Users[targetID]; !ok {
+			return ErrNotExist
+		}
+
+		ids := &user.MutedUserIDs
+		if relationship == RelationshipBlock {
+			ids = &user.BlockedUserIDs
+		}
+
+		i := slices.Index(*ids, targetID)
+		switch {
+		case enabled && i == -1:
+			*ids = append(*ids, targetID)
+		case !enabled && i != -1:
+			*ids = slices.Delete(*ids, i, i+1)
+		default:
+			return errNoChange
+		}
+		dbStructure.Users[userID] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+// HiddenAuthors returns the users whose chirps userID shouldn't see: those
+// they have blocked or muted and those who have blocked them.
+func (db *DB) HiddenAuthors(ctx context.Context, userID int) (map[int]bool, error) {
+	ctx, span := tracer.Start(ctx, "database.HiddenAuthors")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hidden := map[int]bool{}
+	user := dbStructure.Users[userID]
+	for _, id := range user.BlockedUserIDs {
+		hidden[id] = true
+	}
+	for _, id := range user.MutedUserIDs {
+		hidden[id] = true
+	}
+	for id, other := range dbStructure.Users {
+		if slices.Contains(other.BlockedUserIDs, userID) {
+			hidden[id] = true
+		}
+	}
+
+	return hidden, nil
+}
@@ -1,37 +1,35 @@
 package database
 
+import "context"
+
 type Chirp struct {
 	ID       int    `json:"id"`
 	Body     string `json:"body"`
 	AuthorID int    `json:"author_id"`
 }
 
-func (db *DB) CreateChirp(body string, userId int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return Chirp{}, err
-	}
-
-	var chripID int = 1
-
-	totalChirp := len(dbStructure.Chirps)
-	if totalChirp > 0 {
-		chirp, err := db.GetChirp(totalChirp)
-		if err != nil {
-			return Chirp{}, err
+func (db *DB) CreateChirp(ctx context.Context, body string, userId int) (Chirp, error) {
+	ctx, span := tracer.Start(ctx, "database.CreateChirp")
+	defer span.End()
+
+	chirp := Chirp{}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		// Deleted chirps leave gaps, so take the next ID after the highest.
+		chripID := 1
+		for existingID := range dbStructure.Chirps {
+			if existingID >= chripID {
+				chripID = existingID + 1
+			}
 		}
-		chripID = chirp.ID + 1
-	}
-
-	chirp := Chirp{
-		ID:       chripID,
-		Body:     body,
-		AuthorID: userId,
-	}
-	dbStructure.Chirps[chripID] = chirp
-
-	err = db.writeDB(dbStructure)
 
+		chirp = Chirp{
+			ID:       chripID,
+			Body:     body,
+			AuthorID: userId,
+		}
+		dbStructure.Chirps[chripID] = chirp
+		return nil
+	})
 	if err != nil {
 		return Chirp{}, err
 	}
@@ -39,8 +37,11 @@ func (db *DB) CreateChirp(body string, userId int) (Chirp, error) {
 	return chirp, nil
 }
 
-func (db *DB) GetChirps() ([]Chirp, error) {
-	dBStructure, err := db.loadDB()
+func (db *DB) GetChirps(ctx context.Context) ([]Chirp, error) {
+	ctx, span := tracer.Start(ctx, "database.GetChirps")
+	defer span.End()
+
+	dBStructure, err := db.loadDB(ctx)
 	if err != nil {
 		return nil, err
 	}
@@ -52,8 +53,11 @@ func (db *DB) GetChirps() ([]Chirp, error) {
 	return chirps, nil
 }
 
-func (db *DB) GetChirp(id int) (Chirp, error) {
-	dbStructure, err := db.loadDB()
+func (db *DB) GetChirp(ctx context.Context, id int) (Chirp, error) {
+	ctx, span := tracer.Start(ctx, "database.GetChirp")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
 	if err != nil {
 		return Chirp{}, err
 	}
@@ -66,18 +70,40 @@ func (db *DB) GetChirp(id int) (Chirp, error) {
 	return chirp, nil
 }
 
-func (db *DB) DeleteChirp(chripId, userID int) error {
-	dbStructure, err := db.loadDB()
+func (db *DB) UpdateChirp(ctx context.Context, id int, body string) (Chirp, error) {
+	ctx, span := tracer.Start(ctx, "database.UpdateChirp")
+	defer span.End()
+
+	chirp := Chirp{}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		var ok bool
+		chirp, ok = dbStructure.Chirps[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		chirp.Body = body
+		dbStructure.Chirps[id] = chirp
+		return nil
+	})
 	if err != nil {
-		return err
+		return Chirp{}, err
 	}
 
-	chirp, ok := dbStructure.Chirps[chripId]
-	if !ok {
-		return ErrNotExist
-	}
+	return chirp, nil
+}
 
-	delete(dbStructure.Chirps, chirp.ID)
+func (db *DB) DeleteChirp(ctx context.Context, chripId, userID int) error {
+	ctx, span := tracer.Start(ctx, "database.DeleteChirp")
+	defer span.End()
+
+	return db.update(ctx, func(dbStructure *DBStructure) error {
+		chirp, ok := dbStructure.Chirps[chripId]
+		if !ok {
+			return ErrNotExist
+		}
 
-	return db.writeDB(dbStructure)
+		delete(dbStructure.Chirps, chirp.ID)
+		return nil
+	})
 }
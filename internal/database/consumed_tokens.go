@@ -0,0 +1,32 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// ConsumeToken records that the single-use token with the given ID has been
+// used, returning ErrAlreadyExists if it already was. IDs are kept until the
+// token would have expired anyway.
+func (db *DB) ConsumeToken(ctx context.Context, id string, expiresAt time.Time) error {
+	ctx, span := tracer.Start(ctx, "database.ConsumeToken")
+	defer span.End()
+
+	// Checking and recording the ID under one lock stops concurrent
+	// requests from both redeeming the token.
+	return db.update(ctx, func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.ConsumedTokens[id]; ok {
+			return ErrAlreadyExists
+		}
+
+		now := time.Now()
+		for consumedID, consumedExpiresAt := range dbStructure.ConsumedTokens {
+			if consumedExpiresAt.Before(now) {
+				delete(dbStructure.ConsumedTokens, consumedID)
+			}
+		}
+
+		dbStructure.ConsumedTokens[id] = expiresAt
+		return nil
+	})
+}
@@ -1,23 +1,63 @@
 package database
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 )
 
 var ErrNotExist = errors.New("resource does not exist")
 
+// ErrClosed is returned by every operation after Close.
+var ErrClosed = errors.New("database is closed")
+
+var errNoChange = errors.New("nothing to change")
+
+var tracer = otel.Tracer("github.com/keertirajmalik/chirpy/internal/database")
+
 type DB struct {
-	path string
-	mux  *sync.RWMutex
+	path     string
+	mux      *sync.RWMutex
+	observer Observer
+	closed   bool
+}
+
+// Observer is told how long each load or write of the database file took,
+// for metrics.
+type Observer func(operation string, duration time.Duration)
+
+func (db *DB) SetObserver(observer Observer) {
+	db.observer = observer
+}
+
+func (db *DB) observe(operation string, start time.Time) {
+	if db.observer != nil {
+		db.observer(operation, time.Since(start))
+	}
 }
 
 type DBStructure struct {
-	Chirps map[int]Chirp `json:"chirps"`
-	Users  map[int]User  `json:"users"`
-    RefeshTokens map[string]RefreshToken `json:"refresh_tokens"`
+	Chirps       map[int]Chirp           `json:"chirps"`
+	Users        map[int]User            `json:"users"`
+	RefeshTokens map[string]RefreshToken `json:"refresh_tokens"`
+	APITokens    map[int]APIToken        `json:"api_tokens"`
+
+	OAuthClients       map[string]OAuthClient       `json:"oauth_clients"`
+	AuthorizationCodes map[string]AuthorizationCode `json:"authorization_codes"`
+
+	ConsumedTokens map[string]time.Time `json:"consumed_tokens"`
+
+	// LastUserID is the highest user ID ever handed out. IDs aren't reused,
+	// so tokens issued to a purged user can't authenticate as someone new.
+	LastUserID int `json:"last_user_id"`
 }
 
 func NewDB(path string) (*DB, error) {
@@ -33,11 +73,20 @@ func NewDB(path string) (*DB, error) {
 
 func (db *DB) createDB() error {
 	dbStructure := DBStructure{
-		Chirps: map[int]Chirp{},
-		Users:  map[int]User{},
-        RefeshTokens: map[string]RefreshToken{},
+		Chirps:       map[int]Chirp{},
+		Users:        map[int]User{},
+		RefeshTokens: map[string]RefreshToken{},
+		APITokens:    map[int]APIToken{},
+
+		OAuthClients:       map[string]OAuthClient{},
+		AuthorizationCodes: map[string]AuthorizationCode{},
+
+		ConsumedTokens: map[string]time.Time{},
 	}
-	return db.writeDB(dbStructure)
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	return db.write(context.Background(), dbStructure)
 }
 
 func (db *DB) ensureDB() error {
@@ -57,35 +106,136 @@ func (db *DB) ResetDB() error {
 	return db.ensureDB()
 }
 
-func (db *DB) loadDB() (DBStructure, error) {
+// Close waits for any write in progress to finish and makes further
+// operations fail with ErrClosed, so the file isn't changed while the server
+// shuts down.
+func (db *DB) Close() error {
 	db.mux.Lock()
 	defer db.mux.Unlock()
 
+	db.closed = true
+	return nil
+}
+
+// loadDB reads the database for a method that only looks at it. Methods that
+// change it go through update instead.
+func (db *DB) loadDB(ctx context.Context) (DBStructure, error) {
+	db.mux.RLock()
+	defer db.mux.RUnlock()
+
+	return db.load(ctx)
+}
+
+// update runs fn on the current contents of the database and saves the
+// result, holding the lock throughout so no other change can slip in between
+// the read and the write. Nothing is saved if fn returns an error, and fn
+// returns errNoChange to skip the write without failing.
+func (db *DB) update(ctx context.Context, fn func(dbStructure *DBStructure) error) error {
+	db.mux.Lock()
+	defer db.mux.Unlock()
+
+	dbStructure, err := db.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = fn(&dbStructure)
+	if errors.Is(err, errNoChange) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	return db.write(ctx, dbStructure)
+}
+
+// load and write expect the caller to hold db.mux.
+func (db *DB) load(ctx context.Context) (DBStructure, error) {
+	_, span := tracer.Start(ctx, "database.load")
+	defer span.End()
+	defer db.observe("load", time.Now())
+
 	dbStructure := DBStructure{}
+	if db.closed {
+		return dbStructure, ErrClosed
+	}
 	dat, err := os.ReadFile(db.path)
 
 	if errors.Is(err, os.ErrNotExist) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "database file is missing")
 		return dbStructure, err
 	}
 	err = json.Unmarshal(dat, &dbStructure)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "couldn't decode database file")
 		return dbStructure, err
 	}
+
+	// Databases written before a collection existed won't have it yet.
+	if dbStructure.APITokens == nil {
+		dbStructure.APITokens = map[int]APIToken{}
+	}
+	if dbStructure.OAuthClients == nil {
+		dbStructure.OAuthClients = map[string]OAuthClient{}
+	}
+	if dbStructure.AuthorizationCodes == nil {
+		dbStructure.AuthorizationCodes = map[string]AuthorizationCode{}
+	}
+	if dbStructure.ConsumedTokens == nil {
+		dbStructure.ConsumedTokens = map[string]time.Time{}
+	}
 	return dbStructure, nil
 }
 
-func (db *DB) writeDB(dbStructure DBStructure) error {
-	db.mux.Lock()
-	defer db.mux.Unlock()
+func (db *DB) write(ctx context.Context, dbStructure DBStructure) error {
+	_, span := tracer.Start(ctx, "database.write")
+	defer span.End()
+	defer db.observe("write", time.Now())
+
+	if db.closed {
+		return ErrClosed
+	}
 
 	data, err := json.Marshal(dbStructure)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "couldn't encode database")
 		return err
 	}
+	span.SetAttributes(attribute.Int("database.bytes", len(data)))
 
-	err = os.WriteFile(db.path, data, 0600)
+	err = writeFileAtomic(db.path, data)
 	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "couldn't write database file")
 		return err
 	}
 	return nil
 }
+
+// writeFileAtomic writes to a temporary file and renames it over path, so a
+// crash part way through leaves the previous contents in place rather than a
+// truncated file.
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(file.Name(), path)
+}
@@ -0,0 +1,169 @@
+package database
+
+import (
+	"context"
+	"slices"
+	"time"
+)
+
+// ScheduleUserDeletion marks a user to be purged at the given time and signs
+// them out everywhere by removing their refresh and API tokens.
+func (db *DB) ScheduleUserDeletion(ctx context.Context, userID int, at time.Time) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.ScheduleUserDeletion")
+	defer span.End()
+
+	user := User{}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+
+		user.DeletionScheduledAt = &at
+		dbStructure.Users[userID] = user
+
+		for token, refreshToken := range dbStructure.RefeshTokens {
+			if refreshToken.UserID == userID {
+				delete(dbStructure.RefeshTokens, token)
+			}
+		}
+		for id, apiToken := range dbStructure.APITokens {
+			if apiToken.UserID == userID {
+				delete(dbStructure.APITokens, id)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (db *DB) CancelUserDeletion(ctx context.Context, userID int) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.CancelUserDeletion")
+	defer span.End()
+
+	return db.updateUser(ctx, userID, func(user *User) {
+		user.DeletionScheduledAt = nil
+	})
+}
+
+// PurgeDeletedUsers removes every user whose deletion is due along with
+// their chirps, tokens and OAuth clients, and returns how many were removed.
+func (db *DB) PurgeDeletedUsers(ctx context.Context, now time.Time) (int, error) {
+	ctx, span := tracer.Start(ctx, "database.PurgeDeletedUsers")
+	defer span.End()
+
+	// Requests keep writing while the purge runs, so it must hold the lock
+	// throughout or their changes would be overwritten.
+	purged := map[int]bool{}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		for id, user := range dbStructure.Users {
+			if user.DeletionScheduledAt != nil && !user.DeletionScheduledAt.After(now) {
+				purged[id] = true
+				delete(dbStructure.Users, id)
+			}
+		}
+		if len(purged) == 0 {
+			return errNoChange
+		}
+
+		for id, user := range dbStructure.Users {
+			user.BlockedUserIDs = slices.DeleteFunc(user.BlockedUserIDs, func(id int) bool { return purged[id] })
+			user.MutedUserIDs = slices.DeleteFunc(user.MutedUserIDs, func(id int) bool { return purged[id] })
+			dbStructure.Users[id] = user
+		}
+		for id, chirp := range dbStructure.Chirps {
+			if purged[chirp.AuthorID] {
+				delete(dbStructure.Chirps, id)
+			}
+		}
+		for id, apiToken := range dbStructure.APITokens {
+			if purged[apiToken.UserID] {
+				delete(dbStructure.APITokens, id)
+			}
+		}
+
+		purgedClients := map[string]bool{}
+		for id, client := range dbStructure.OAuthClients {
+			if purged[client.OwnerID] {
+				purgedClients[id] = true
+				delete(dbStructure.OAuthClients, id)
+			}
+		}
+		for code, authorizationCode := range dbStructure.AuthorizationCodes {
+			if purged[authorizationCode.UserID] || purgedClients[authorizationCode.ClientID] {
+				delete(dbStructure.AuthorizationCodes, code)
+			}
+		}
+		for token, refreshToken := range dbStructure.RefeshTokens {
+			if purged[refreshToken.UserID] || purgedClients[refreshToken.ClientID] {
+				delete(dbStructure.RefeshTokens, token)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(purged), nil
+}
+
+// UserData is everything stored about a user, for exporting it to them.
+type UserData struct {
+	User          User           `json:"user"`
+	Chirps        []Chirp        `json:"chirps"`
+	RefreshTokens []RefreshToken `json:"refresh_tokens"`
+	APITokens     []APIToken     `json:"api_tokens"`
+	OAuthClients  []OAuthClient  `json:"oauth_clients"`
+}
+
+func (db *DB) GetUserData(ctx context.Context, userID int) (UserData, error) {
+	ctx, span := tracer.Start(ctx, "database.GetUserData")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
+	if err != nil {
+		return UserData{}, err
+	}
+
+	user, ok := dbStructure.Users[userID]
+	if !ok {
+		return UserData{}, ErrNotExist
+	}
+
+	data := UserData{
+		User:          user,
+		Chirps:        []Chirp{},
+		RefreshTokens: []RefreshToken{},
+		APITokens:     []APIToken{},
+		OAuthClients:  []OAuthClient{},
+	}
+	for _, chirp := range dbStructure.Chirps {
+		if chirp.AuthorID == userID {
+			data.Chirps = append(data.Chirps, chirp)
+		}
+	}
+	for _, refreshToken := range dbStructure.RefeshTokens {
+		if refreshToken.UserID == userID {
+			data.RefreshTokens = append(data.RefreshTokens, refreshToken)
+		}
+	}
+	for _, apiToken := range dbStructure.APITokens {
+		if apiToken.UserID == userID {
+			data.APITokens = append(data.APITokens, apiToken)
+		}
+	}
+	for _, client := range dbStructure.OAuthClients {
+		if client.OwnerID == userID {
+			data.OAuthClients = append(data.OAuthClients, client)
+		}
+	}
+
+	return data, nil
+}
@@ -0,0 +1,66 @@
+package database
+
+import "context"
+
+func (db *DB) GetUserByExternalIdentity(ctx context.Context, identity ExternalIdentity) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.GetUserByExternalIdentity")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
+	if err != nil {
+		return User{}, err
+	}
+
+	for _, user := range dbStructure.Users {
+		for _, linked := range user.ExternalIdentities {
+			if linked == identity {
+				return user, nil
+			}
+		}
+	}
+
+	return User{}, ErrNotExist
+}
+
+func (db *DB) LinkExternalIdentity(ctx context.Context, userID int, identity ExternalIdentity) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.LinkExternalIdentity")
+	defer span.End()
+
+	// Only identities with a verified email matching the user's are linked,
+	// so the provider has vouched for the address.
+	return db.updateUser(ctx, userID, func(user *User) {
+		user.ExternalIdentities = append(user.ExternalIdentities, identity)
+		user.EmailVerified = true
+	})
+}
+
+// CreateExternalUser creates a user who signs in through an identity
+// provider and so has no password.
+func (db *DB) CreateExternalUser(ctx context.Context, email string, identity ExternalIdentity) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.CreateExternalUser")
+	defer span.End()
+
+	email = NormalizeEmail(email)
+
+	user := User{}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		if emailTaken(*dbStructure, 0, email) {
+			return ErrAlreadyExists
+		}
+
+		id := nextUserID(dbStructure)
+		user = User{
+			ID:                 id,
+			Email:              email,
+			EmailVerified:      true,
+			ExternalIdentities: []ExternalIdentity{identity},
+		}
+		dbStructure.Users[id] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
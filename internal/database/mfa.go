@@ -0,0 +1,98 @@
+package database
+
+import (
+	"context"
+	"errors"
+)
+
+var ErrTOTPCodeReused = errors.New("TOTP code already used")
+
+// SetPendingTOTPSecret stores a freshly generated secret that only takes
+// effect once EnableTOTP is called after the user proves they can use it.
+func (db *DB) SetPendingTOTPSecret(ctx context.Context, userID int, secret string) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.SetPendingTOTPSecret")
+	defer span.End()
+
+	user := User{}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+
+		if user.TOTPEnabled {
+			return ErrAlreadyExists
+		}
+
+		user.TOTPSecret = secret
+		user.TOTPLastCounter = 0
+		dbStructure.Users[userID] = user
+		return nil
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return user, nil
+}
+
+func (db *DB) EnableTOTP(ctx context.Context, userID int, counter int64, hashedRecoveryCodes []string) error {
+	ctx, span := tracer.Start(ctx, "database.EnableTOTP")
+	defer span.End()
+
+	_, err := db.updateUser(ctx, userID, func(user *User) {
+		user.TOTPEnabled = true
+		user.TOTPLastCounter = counter
+		user.RecoveryCodes = hashedRecoveryCodes
+	})
+	return err
+}
+
+// UseTOTPCounter records the time step of an accepted code so the same code
+// can't be replayed within its validity window. The check and the write
+// happen under one lock, so concurrent logins can't both use a step.
+func (db *DB) UseTOTPCounter(ctx context.Context, userID int, counter int64) error {
+	ctx, span := tracer.Start(ctx, "database.UseTOTPCounter")
+	defer span.End()
+
+	return db.update(ctx, func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+
+		if counter <= user.TOTPLastCounter {
+			return ErrTOTPCodeReused
+		}
+
+		user.TOTPLastCounter = counter
+		dbStructure.Users[userID] = user
+		return nil
+	})
+}
+
+// UseRecoveryCode consumes a hashed recovery code, returning ErrNotExist if
+// the user has no such unused code. Like UseTOTPCounter, it can't accept the
+// same code twice even for concurrent logins.
+func (db *DB) UseRecoveryCode(ctx context.Context, userID int, hashedCode string) error {
+	ctx, span := tracer.Start(ctx, "database.UseRecoveryCode")
+	defer span.End()
+
+	return db.update(ctx, func(dbStructure *DBStructure) error {
+		user, ok := dbStructure.Users[userID]
+		if !ok {
+			return ErrNotExist
+		}
+
+		for i, code := range user.RecoveryCodes {
+			if code == hashedCode {
+				user.RecoveryCodes = append(user.RecoveryCodes[:i], user.RecoveryCodes[i+1:]...)
+				dbStructure.Users[userID] = user
+				return nil
+			}
+		}
+
+		return ErrNotExist
+	})
+}
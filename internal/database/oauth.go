@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"time"
+)
+
+// OAuthClient is a third-party application registered to act on behalf of
+// Chirpy users. Public clients, such as single page and mobile apps, have no
+// secret and rely on PKCE alone.
+type OAuthClient struct {
+	ID           string    `json:"id"`
+	OwnerID      int       `json:"owner_id"`
+	Name         string    `json:"name"`
+	HashedSecret string    `json:"hashed_secret,omitempty"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type AuthorizationCode struct {
+	HashedCode          string    `json:"hashed_code"`
+	ClientID            string    `json:"client_id"`
+	UserID              int       `json:"user_id"`
+	RedirectURI         string    `json:"redirect_uri"`
+	Scope               string    `json:"scope"`
+	CodeChallenge       string    `json:"code_challenge"`
+	CodeChallengeMethod string    `json:"code_challenge_method"`
+	ExpiresAt           time.Time `json:"expires_at"`
+}
+
+func (db *DB) CreateOAuthClient(ctx context.Context, id string, ownerID int, name, hashedSecret string, redirectURIs []string) (OAuthClient, error) {
+	ctx, span := tracer.Start(ctx, "database.CreateOAuthClient")
+	defer span.End()
+
+	client := OAuthClient{
+		ID:           id,
+		OwnerID:      ownerID,
+		Name:         name,
+		HashedSecret: hashedSecret,
+		RedirectURIs: redirectURIs,
+		CreatedAt:    time.Now().UTC(),
+	}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		if _, ok := dbStructure.OAuthClients[id]; ok {
+			return ErrAlreadyExists
+		}
+
+		dbStructure.OAuthClients[id] = client
+		return nil
+	})
+	if err != nil {
+		return OAuthClient{}, err
+	}
+
+	return client, nil
+}
+
+func (db *DB) GetOAuthClient(ctx context.Context, id string) (OAuthClient, error) {
+	ctx, span := tracer.Start(ctx, "database.GetOAuthClient")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
+	if err != nil {
+		return OAuthClient{}, err
+	}
+
+	client, ok := dbStructure.OAuthClients[id]
+	if !ok {
+		return OAuthClient{}, ErrNotExist
+	}
+
+	return client, nil
+}
+
+func (db *DB) SaveAuthorizationCode(ctx context.Context, code AuthorizationCode) error {
+	ctx, span := tracer.Start(ctx, "database.SaveAuthorizationCode")
+	defer span.End()
+
+	return db.update(ctx, func(dbStructure *DBStructure) error {
+		dbStructure.AuthorizationCodes[code.HashedCode] = code
+		return nil
+	})
+}
+
+// ConsumeAuthorizationCode returns the code and deletes it so it can only be
+// exchanged once. Expired codes are reported as ErrNotExist.
+func (db *DB) ConsumeAuthorizationCode(ctx context.Context, hashedCode string) (AuthorizationCode, error) {
+	ctx, span := tracer.Start(ctx, "database.ConsumeAuthorizationCode")
+	defer span.End()
+
+	// The code is looked up and deleted under one lock, so concurrent
+	// requests can't both redeem it.
+	code := AuthorizationCode{}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		var ok bool
+		code, ok = dbStructure.AuthorizationCodes[hashedCode]
+		if !ok {
+			return ErrNotExist
+		}
+
+		delete(dbStructure.AuthorizationCodes, hashedCode)
+		return nil
+	})
+	if err != nil {
+		return AuthorizationCode{}, err
+	}
+
+	if code.ExpiresAt.Before(time.Now()) {
+		return AuthorizationCode{}, ErrNotExist
+	}
+
+	return code, nil
+}
@@ -0,0 +1,45 @@
+package database
+
+import (
+	"context"
+	"strings"
+)
+
+// Profile is the public part of a user. Handles are unique regardless of
+// case.
+type Profile struct {
+	Handle      string `json:"handle,omitempty"`
+	DisplayName string `json:"display_name,omitempty"`
+	Bio         string `json:"bio,omitempty"`
+	AvatarURL   string `json:"avatar_url,omitempty"`
+}
+
+func handleTaken(dbStructure DBStructure, id int, handle string) bool {
+	if handle == "" {
+		return false
+	}
+	for _, other := range dbStructure.Users {
+		if other.ID != id && strings.EqualFold(other.Handle, handle) {
+			return true
+		}
+	}
+	return false
+}
+
+func (db *DB) GetUserByHandle(ctx context.Context, handle string) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.GetUserByHandle")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
+	if err != nil {
+		return User{}, err
+	}
+
+	for _, user := range dbStructure.Users {
+		if user.Handle != "" && strings.EqualFold(user.Handle, handle) {
+			return user, nil
+		}
+	}
+
+	return User{}, ErrNotExist
+}
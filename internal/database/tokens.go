@@ -1,36 +1,49 @@
 package database
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
 type RefreshToken struct {
 	UserID    int       `json:"user_id"`
 	Token     string    `json:"token"`
 	ExpiresAt time.Time `json:"expires_at"`
+
+	// ClientID and Scope are set when the token was issued to an OAuth client.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
 }
 
-func (db *DB) SaveRefreshToken(userID int, token string) error {
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return err
-	}
+func (db *DB) SaveRefreshToken(ctx context.Context, userID int, token string, expiresAt time.Time) error {
+	ctx, span := tracer.Start(ctx, "database.SaveRefreshToken")
+	defer span.End()
+
+	return db.SaveClientRefreshToken(ctx, userID, token, "", "", expiresAt)
+}
+
+func (db *DB) SaveClientRefreshToken(ctx context.Context, userID int, token, clientID, scope string, expiresAt time.Time) error {
+	ctx, span := tracer.Start(ctx, "database.SaveClientRefreshToken")
+	defer span.End()
 
 	refreshToken := RefreshToken{
 		UserID:    userID,
 		Token:     token,
-		ExpiresAt: time.Now().Add(time.Hour),
+		ExpiresAt: expiresAt,
+		ClientID:  clientID,
+		Scope:     scope,
 	}
-	dbStructure.RefeshTokens[token] = refreshToken
-
-	err = db.writeDB(dbStructure)
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return db.update(ctx, func(dbStructure *DBStructure) error {
+		dbStructure.RefeshTokens[token] = refreshToken
+		return nil
+	})
 }
 
-func (db *DB) UserForRefershToken(token string) (User, error) {
-	dbStructure, err := db.loadDB()
+func (db *DB) UserForRefershToken(ctx context.Context, token string) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.UserForRefershToken")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
 	if err != nil {
 		return User{}, err
 	}
@@ -44,7 +57,13 @@ func (db *DB) UserForRefershToken(token string) (User, error) {
 		return User{}, ErrNotExist
 	}
 
-	user, err := db.GetUser(refreshToken.UserID)
+	// Tokens issued to OAuth clients are limited to their granted scopes and
+	// must go through the token endpoint instead.
+	if refreshToken.ClientID != "" {
+		return User{}, ErrNotExist
+	}
+
+	user, err := db.GetUser(ctx, refreshToken.UserID)
 	if err != nil {
 		return User{}, err
 	}
@@ -52,13 +71,44 @@ func (db *DB) UserForRefershToken(token string) (User, error) {
 	return user, nil
 }
 
-func (db *DB) RevokeToken(token string) error {
-	dbStructure, err := db.loadDB()
+func (db *DB) GetRefreshToken(ctx context.Context, token string) (RefreshToken, error) {
+	ctx, span := tracer.Start(ctx, "database.GetRefreshToken")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
 	if err != nil {
-		return err
+		return RefreshToken{}, err
+	}
+
+	refreshToken, ok := dbStructure.RefeshTokens[token]
+	if !ok || refreshToken.ExpiresAt.Before(time.Now()) {
+		return RefreshToken{}, ErrNotExist
 	}
 
-	delete(dbStructure.RefeshTokens, token)
+	return refreshToken, nil
+}
+
+func (db *DB) RevokeToken(ctx context.Context, token string) error {
+	ctx, span := tracer.Start(ctx, "database.RevokeToken")
+	defer span.End()
+
+	return db.update(ctx, func(dbStructure *DBStructure) error {
+		delete(dbStructure.RefeshTokens, token)
+		return nil
+	})
+}
+
+// RevokeUserRefreshTokens signs a user out of every session.
+func (db *DB) RevokeUserRefreshTokens(ctx context.Context, userID int) error {
+	ctx, span := tracer.Start(ctx, "database.RevokeUserRefreshTokens")
+	defer span.End()
 
-	return db.writeDB(dbStructure)
+	return db.update(ctx, func(dbStructure *DBStructure) error {
+		for token, refreshToken := range dbStructure.RefeshTokens {
+			if refreshToken.UserID == userID {
+				delete(dbStructure.RefeshTokens, token)
+			}
+		}
+		return nil
+	})
 }
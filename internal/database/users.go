@@ -1,38 +1,79 @@
 package database
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"strings"
+	"time"
 )
 
 type User struct {
 	ID             int    `json:"id"`
 	Email          string `json:"email"`
 	HashedPassword string `json:"hashed_password"`
+	EmailVerified  bool   `json:"email_verified"`
+	IsChirpyRed    bool   `json:"is_chirpy_red"`
+	Role           string `json:"role,omitempty"`
+	Profile
+
+	TOTPSecret      string   `json:"totp_secret,omitempty"`
+	TOTPEnabled     bool     `json:"totp_enabled"`
+	TOTPLastCounter int64    `json:"totp_last_counter,omitempty"`
+	RecoveryCodes   []string `json:"recovery_codes,omitempty"`
+
+	ExternalIdentities []ExternalIdentity `json:"external_identities,omitempty"`
+
+	BlockedUserIDs []int `json:"blocked_user_ids,omitempty"`
+	MutedUserIDs   []int `json:"muted_user_ids,omitempty"`
+
+	// DeletionScheduledAt is set when the user has asked for their account to
+	// be deleted. It is purged once that time has passed unless restored.
+	DeletionScheduledAt *time.Time `json:"deletion_scheduled_at,omitempty"`
+}
+
+// ExternalIdentity links a user to an account at an OpenID Connect provider.
+type ExternalIdentity struct {
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
 }
 
 var ErrAlreadyExists = errors.New("already exists")
 
-func (db *DB) CreateUser(email, hashedPassword string) (User, error) {
+// ErrEmailTaken and ErrHandleTaken say which field of an account update
+// clashed with another user. Both wrap ErrAlreadyExists.
+var (
+	ErrEmailTaken  = fmt.Errorf("email %w", ErrAlreadyExists)
+	ErrHandleTaken = fmt.Errorf("handle %w", ErrAlreadyExists)
+)
 
-	if _, err := db.GetUserByEmail(email); !errors.Is(err, ErrNotExist) {
-		return User{}, ErrAlreadyExists
-	}
+// NormalizeEmail puts an email address in the form it is stored and looked
+// up in, so addresses that differ only in case belong to the same account.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
 
-	dbStructure, err := db.loadDB()
-	if err != nil {
-		return User{}, err
-	}
+func (db *DB) CreateUser(ctx context.Context, email, hashedPassword string) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.CreateUser")
+	defer span.End()
 
-	id := len(dbStructure.Users) + 1
-	user := User{
-		ID:             id,
-		Email:          email,
-		HashedPassword: hashedPassword,
-	}
-	dbStructure.Users[id] = user
+	email = NormalizeEmail(email)
 
-	err = db.writeDB(dbStructure)
+	user := User{}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		if emailTaken(*dbStructure, 0, email) {
+			return ErrAlreadyExists
+		}
 
+		id := nextUserID(dbStructure)
+		user = User{
+			ID:             id,
+			Email:          email,
+			HashedPassword: hashedPassword,
+		}
+		dbStructure.Users[id] = user
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
@@ -40,8 +81,11 @@ func (db *DB) CreateUser(email, hashedPassword string) (User, error) {
 	return user, nil
 }
 
-func (db *DB) GetUser(id int) (User, error) {
-	dbStructure, err := db.loadDB()
+func (db *DB) GetUser(ctx context.Context, id int) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.GetUser")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
 	if err != nil {
 		return User{}, err
 	}
@@ -54,14 +98,41 @@ func (db *DB) GetUser(id int) (User, error) {
 	return user, nil
 }
 
-func (db *DB) GetUserByEmail(email string) (User, error) {
-	dbStructure, err := db.loadDB()
+// GetUsers returns the users with the given IDs, keyed by ID, reading the
+// database once. IDs with no user are left out.
+func (db *DB) GetUsers(ctx context.Context, ids []int) (map[int]User, error) {
+	ctx, span := tracer.Start(ctx, "database.GetUsers")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	users := make(map[int]User, len(ids))
+	for _, id := range ids {
+		if user, ok := dbStructure.Users[id]; ok {
+			users[id] = user
+		}
+	}
+
+	return users, nil
+}
+
+func (db *DB) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.GetUserByEmail")
+	defer span.End()
+
+	dbStructure, err := db.loadDB(ctx)
 	if err != nil {
 		return User{}, err
 	}
 
+	// Compare normalized forms, as accounts created before emails were
+	// normalized may still have capitals.
+	email = NormalizeEmail(email)
 	for _, user := range dbStructure.Users {
-		if user.Email == email {
+		if NormalizeEmail(user.Email) == email {
 			return user, nil
 		}
 	}
@@ -69,25 +140,136 @@ func (db *DB) GetUserByEmail(email string) (User, error) {
 	return User{}, ErrNotExist
 }
 
-func (db *DB) UpdateUser(id int, email, hashedPassword string) (User, error) {
-	dbStructure, err := db.loadDB()
+// AccountUpdate lists the changes to make to an account. Nil fields are left
+// as they are.
+type AccountUpdate struct {
+	Email          *string
+	HashedPassword *string
+	Profile        *Profile
+}
+
+// UpdateAccount applies every change in update in a single write, so a
+// clash with another user's email or handle leaves the account untouched.
+// A changed email needs verifying again.
+func (db *DB) UpdateAccount(ctx context.Context, id int, update AccountUpdate) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.UpdateAccount")
+	defer span.End()
+
+	if update.Email != nil {
+		email := NormalizeEmail(*update.Email)
+		update.Email = &email
+	}
+
+	user := User{}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		if update.Email != nil && emailTaken(*dbStructure, id, *update.Email) {
+			return ErrEmailTaken
+		}
+		if update.Profile != nil && handleTaken(*dbStructure, id, update.Profile.Handle) {
+			return ErrHandleTaken
+		}
+
+		if update.Email != nil && *update.Email != user.Email {
+			user.Email = *update.Email
+			user.EmailVerified = false
+		}
+		if update.HashedPassword != nil {
+			user.HashedPassword = *update.HashedPassword
+		}
+		if update.Profile != nil {
+			user.Profile = *update.Profile
+		}
+		dbStructure.Users[id] = user
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
-	user, ok := dbStructure.Users[id]
-	if !ok {
-		return User{}, ErrNotExist
+	return user, nil
+}
+
+func emailTaken(dbStructure DBStructure, id int, email string) bool {
+	for _, other := range dbStructure.Users {
+		if other.ID != id && NormalizeEmail(other.Email) == NormalizeEmail(email) {
+			return true
+		}
 	}
+	return false
+}
+
+func (db *DB) UpdatePassword(ctx context.Context, id int, hashedPassword string) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.UpdatePassword")
+	defer span.End()
 
-	user.Email = email
-	user.HashedPassword = hashedPassword
-	dbStructure.Users[id] = user
+	return db.updateUser(ctx, id, func(user *User) {
+		user.HashedPassword = hashedPassword
+	})
+}
+
+func (db *DB) MarkEmailVerified(ctx context.Context, id int) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.MarkEmailVerified")
+	defer span.End()
+
+	return db.updateUser(ctx, id, func(user *User) {
+		user.EmailVerified = true
+	})
+}
+
+func (db *DB) SetRole(ctx context.Context, id int, role string) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.SetRole")
+	defer span.End()
+
+	return db.updateUser(ctx, id, func(user *User) {
+		user.Role = role
+	})
+}
+
+// UpgradeUser gives a user a Chirpy Red membership.
+func (db *DB) UpgradeUser(ctx context.Context, id int) (User, error) {
+	ctx, span := tracer.Start(ctx, "database.UpgradeUser")
+	defer span.End()
 
-	err = db.writeDB(dbStructure)
+	return db.updateUser(ctx, id, func(user *User) {
+		user.IsChirpyRed = true
+	})
+}
+
+// updateUser applies change to the user with id in a single update.
+func (db *DB) updateUser(ctx context.Context, id int, change func(user *User)) (User, error) {
+	user := User{}
+	err := db.update(ctx, func(dbStructure *DBStructure) error {
+		var ok bool
+		user, ok = dbStructure.Users[id]
+		if !ok {
+			return ErrNotExist
+		}
+
+		change(&user)
+		dbStructure.Users[id] = user
+		return nil
+	})
 	if err != nil {
 		return User{}, err
 	}
 
 	return user, nil
 }
+
+// nextUserID hands out a new user ID, above any ever used before.
+func nextUserID(dbStructure *DBStructure) int {
+	// Databases written before LastUserID existed start from the highest
+	// remaining ID.
+	for existingID := range dbStructure.Users {
+		dbStructure.LastUserID = max(dbStructure.LastUserID, existingID)
+	}
+
+	dbStructure.LastUserID++
+	return dbStructure.LastUserID
+}
@@ -0,0 +1,136 @@
+// Package lockout tracks failed attempts per key, such as an account or an
+// IP address, and locks the key out for an exponentially growing period once
+// too many attempts have failed.
+package lockout
+
+import (
+	"slices"
+	"sync"
+	"time"
+)
+
+// maxEntries bounds memory use. Once reached, stale entries are pruned and
+// then the oldest are evicted.
+const maxEntries = 10000
+
+type Config struct {
+	// Threshold is the number of failures allowed before the key is locked.
+	Threshold int
+	// BaseDelay is how long the key is locked after reaching Threshold. Each
+	// further failure doubles it, up to MaxDelay.
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// ResetAfter forgets failures when there have been none for this long.
+	ResetAfter time.Duration
+}
+
+type Tracker struct {
+	config  Config
+	mux     sync.Mutex
+	entries map[string]*entry
+}
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+func New(config Config) *Tracker {
+	return &Tracker{
+		config:  config,
+		entries: map[string]*entry{},
+	}
+}
+
+// Attempt reserves an attempt for key, returning how much longer key is
+// locked out for, or zero if the attempt may go ahead. The attempt counts as
+// failed from the start, so parallel guesses can't all get in before any of
+// them fails; call Succeed or Reset if it turns out to be genuine.
+func (t *Tracker) Attempt(key string) time.Duration {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	now := time.Now()
+	e, ok := t.entries[key]
+	if ok {
+		if remaining := e.lockedUntil.Sub(now); remaining > 0 {
+			return remaining
+		}
+	}
+
+	if !ok || now.Sub(e.lastFailure) > t.config.ResetAfter {
+		if len(t.entries) >= maxEntries {
+			t.prune(now)
+		}
+		e = &entry{}
+		t.entries[key] = e
+	}
+
+	e.failures++
+	e.lastFailure = now
+
+	if e.failures >= t.config.Threshold {
+		delay := t.config.BaseDelay
+		for i := t.config.Threshold; i < e.failures && delay < t.config.MaxDelay; i++ {
+			delay *= 2
+		}
+		e.lockedUntil = now.Add(min(delay, t.config.MaxDelay))
+	}
+	return 0
+}
+
+// Succeed takes back the failure counted by Attempt, for an attempt that
+// turned out to be genuine but shouldn't clear earlier failures.
+func (t *Tracker) Succeed(key string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	e, ok := t.entries[key]
+	if !ok {
+		return
+	}
+
+	e.failures--
+	if e.failures <= 0 {
+		delete(t.entries, key)
+		return
+	}
+	if e.failures < t.config.Threshold {
+		e.lockedUntil = time.Time{}
+	}
+}
+
+// Reset clears all failures for key, unlocking it.
+func (t *Tracker) Reset(key string) {
+	t.mux.Lock()
+	defer t.mux.Unlock()
+
+	delete(t.entries, key)
+}
+
+// prune makes room for a new entry. Stale entries go first; if that isn't
+// enough, the ones that failed longest ago are evicted.
+func (t *Tracker) prune(now time.Time) {
+	for key, e := range t.entries {
+		if now.Sub(e.lastFailure) > t.config.ResetAfter && now.After(e.lockedUntil) {
+			delete(t.entries, key)
+		}
+	}
+	if len(t.entries) < maxEntries {
+		return
+	}
+
+	// Evict a tenth at a time so a flood of new keys doesn't sort the whole
+	// map on every attempt.
+	keys := make([]string, 0, len(t.entries))
+	for key := range t.entries {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return t.entries[a].lastFailure.Compare(t.entries[b].lastFailure)
+	})
+	for _, key := range keys[:len(keys)-maxEntries*9/10] {
+		delete(t.entries, key)
+	}
+}
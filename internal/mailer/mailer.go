@@ -0,0 +1,162 @@
+// Package mailer sends transactional email such as verification and
+// password reset messages.
+package mailer
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer delivers mail through an SMTP server. For local development it
+// can point at a stand-in such as MailHog or Mailpit.
+type SMTPMailer struct {
+	Addr     string
+	From     mail.Address
+	Username string
+	Password string
+}
+
+// Send gives up once ctx is done, whether it is still connecting or already
+// talking to the server.
+func (m SMTPMailer) Send(ctx context.Context, msg Message) error {
+	host, _, err := net.SplitHostPort(m.Addr)
+	if err != nil {
+		return err
+	}
+
+	dialer := net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", m.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// The SMTP client doesn't take a context, so bound the whole exchange
+	// with a deadline and close the connection early if ctx is canceled.
+	if deadline, ok := ctx.Deadline(); ok {
+		err = conn.SetDeadline(deadline)
+		if err != nil {
+			return err
+		}
+	}
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	err = m.send(conn, host, msg)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+func (m SMTPMailer) send(conn net.Conn, host string, msg Message) error {
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+	if m.Username != "" {
+		err = client.Auth(smtp.PlainAuth("", m.Username, m.Password, host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(m.From.Address)
+	if err != nil {
+		return err
+	}
+	err = client.Rcpt(msg.To)
+	if err != nil {
+		return err
+	}
+	data, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = data.Write(format(m.From, msg))
+	if err != nil {
+		return err
+	}
+	err = data.Close()
+	if err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// FileMailer appends each message to a file instead of sending it.
+type FileMailer struct {
+	Path string
+	From mail.Address
+
+	mux sync.Mutex
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+
+	file, err := os.OpenFile(m.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(format(m.From, msg), '\n'))
+	return err
+}
+
+// LogMailer logs who each message is for instead of sending it. Bodies hold
+// live verification and password reset tokens, so they are left out; use
+// FileMailer to read them during development.
+type LogMailer struct{}
+
+func (m LogMailer) Send(ctx context.Context, msg Message) error {
+	slog.InfoContext(ctx, "Mail", "to", msg.To, "subject", msg.Subject)
+	return nil
+}
+
+// headerValue strips line breaks so user-supplied values such as an email
+// address can't inject extra headers.
+var headerValue = strings.NewReplacer("\r", "", "\n", "").Replace
+
+func format(from mail.Address, msg Message) []byte {
+	headers := []string{
+		"From: " + from.String(),
+		"To: " + headerValue(msg.To),
+		"Subject: " + headerValue(msg.Subject),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+	}
+
+	return []byte(fmt.Sprintf("%s\r\n\r\n%s\r\n", strings.Join(headers, "\r\n"), strings.ReplaceAll(msg.Body, "\n", "\r\n")))
+}
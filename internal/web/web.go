@@ -0,0 +1,237 @@
+// Package web serves the web app from assets embedded in the binary, so the
+// server can be deployed without any files alongside it.
+package web
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+)
+
+//go:embed static
+var static embed.FS
+
+const (
+	// Fingerprinted names change whenever the file does, so they can be
+	// cached for good.
+	cacheImmutable = "public, max-age=31536000, immutable"
+	// Everything else must be revalidated against its ETag before reuse.
+	cacheRevalidate = "no-cache"
+)
+
+// Handler serves the embedded assets by their path relative to where it is
+// mounted. Every file other than an HTML page is also served under a
+// fingerprinted name, such as assets/logo.1a2b3c4d5e6f7a8b.png, which pages
+// link to through the asset template function.
+type Handler struct {
+	assets map[string]*asset
+}
+
+type asset struct {
+	contentType  string
+	cacheControl string
+	// variants are in order of preference, ending with the uncompressed
+	// file.
+	variants []variant
+}
+
+type variant struct {
+	encoding string
+	etag     string
+	body     []byte
+}
+
+// New prepares the assets, compressing them up front so requests don't pay
+// for it. prefix is the URL path the handler is mounted at, such as "/app/".
+func New(prefix string) (*Handler, error) {
+	h := &Handler{assets: map[string]*asset{}}
+
+	fingerprinted := map[string]string{}
+	var pages []string
+	err := fs.WalkDir(static, "static", func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+
+		name = strings.TrimPrefix(name, "static/")
+		if path.Ext(name) == ".html" {
+			pages = append(pages, name)
+			return nil
+		}
+
+		body, err := static.ReadFile("static/" + name)
+		if err != nil {
+			return err
+		}
+		variants, err := encode(body)
+		if err != nil {
+			return err
+		}
+
+		fingerprint := strings.TrimSuffix(name, path.Ext(name)) + "." + contentHash(body)[:16] + path.Ext(name)
+		fingerprinted[name] = fingerprint
+		h.assets[name] = &asset{contentType: contentType(name, body), cacheControl: cacheRevalidate, variants: variants}
+		h.assets[fingerprint] = &asset{contentType: contentType(name, body), cacheControl: cacheImmutable, variants: variants}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	funcs := template.FuncMap{
+		"asset": func(name string) (string, error) {
+			fingerprint, ok := fingerprinted[name]
+			if !ok {
+				return "", fmt.Errorf("no asset named %s", name)
+			}
+			return prefix + fingerprint, nil
+		},
+	}
+	for _, name := range pages {
+		tmpl, err := template.New(path.Base(name)).Funcs(funcs).ParseFS(static, "static/"+name)
+		if err != nil {
+			return nil, err
+		}
+		page := bytes.Buffer{}
+		err = tmpl.Execute(&page, nil)
+		if err != nil {
+			return nil, err
+		}
+		variants, err := encode(page.Bytes())
+		if err != nil {
+			return nil, err
+		}
+
+		a := &asset{contentType: contentType(name, page.Bytes()), cacheControl: cacheRevalidate, variants: variants}
+		h.assets[name] = a
+		// Directories are served through their index page.
+		if path.Base(name) == "index.html" {
+			h.assets[strings.TrimSuffix(name, "index.html")] = a
+		}
+	}
+
+	return h, nil
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.assets[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	v := a.variants[len(a.variants)-1]
+	for _, candidate := range a.variants[:len(a.variants)-1] {
+		if acceptsEncoding(r.Header.Get("Accept-Encoding"), candidate.encoding) {
+			v = candidate
+			break
+		}
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", a.contentType)
+	header.Set("Cache-Control", a.cacheControl)
+	header.Set("ETag", v.etag)
+	if len(a.variants) > 1 {
+		header.Add("Vary", "Accept-Encoding")
+	}
+	if v.encoding != "" {
+		header.Set("Content-Encoding", v.encoding)
+	}
+
+	// ServeContent answers If-None-Match and range requests.
+	http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(v.body))
+}
+
+// encode returns the brotli and gzip versions of body that are smaller than
+// it, followed by body itself. Each has its own strong ETag, as they differ
+// byte for byte.
+func encode(body []byte) ([]variant, error) {
+	hash := contentHash(body)
+	variants := []variant{}
+
+	compressed := bytes.Buffer{}
+	br := brotli.NewWriterLevel(&compressed, brotli.BestCompression)
+	_, err := br.Write(body)
+	if err == nil {
+		err = br.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if compressed.Len() < len(body) {
+		variants = append(variants, variant{encoding: "br", etag: `"` + hash + `-br"`, body: compressed.Bytes()})
+	}
+
+	compressed = bytes.Buffer{}
+	gz, err := gzip.NewWriterLevel(&compressed, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	_, err = gz.Write(body)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if compressed.Len() < len(body) {
+		variants = append(variants, variant{encoding: "gzip", etag: `"` + hash + `-gzip"`, body: compressed.Bytes()})
+	}
+
+	return append(variants, variant{etag: `"` + hash + `"`, body: body}), nil
+}
+
+func contentHash(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+func contentType(name string, body []byte) string {
+	if typ := mime.TypeByExtension(path.Ext(name)); typ != "" {
+		return typ
+	}
+	return http.DetectContentType(body)
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows encoding,
+// either by name or through "*", with a non-zero quality.
+func acceptsEncoding(header, encoding string) bool {
+	accepted := false
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.TrimSpace(name)
+		if name != "*" && !strings.EqualFold(name, encoding) {
+			continue
+		}
+
+		quality := 1.0
+		key, value, ok := strings.Cut(params, "=")
+		if ok && strings.TrimSpace(key) == "q" {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err == nil {
+				quality = parsed
+			}
+		}
+
+		// A named encoding overrides "*".
+		if name != "*" {
+			return quality > 0
+		}
+		accepted = quality > 0
+	}
+	return accepted
+}
@@ -0,0 +1,84 @@
+package web_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/keertirajmalik/chirpy/internal/web"
+)
+
+// TestServesOnlyTheWebApp mounts the handler the way main does and checks
+// that files next to the server, which older versions served from the
+// working directory, can't be fetched through /app/.
+func TestServesOnlyTheWebApp(t *testing.T) {
+	const secret = "do-not-serve-this"
+
+	dir := t.TempDir()
+	for _, name := range []string{".env", "database.json", "go.mod"} {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(secret), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	handler, err := web.New("/app/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("GET /app/", http.StripPrefix("/app/", handler))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	tests := []struct {
+		path      string
+		wantIndex bool
+	}{
+		{path: "/app/.env"},
+		{path: "/app/database.json"},
+		{path: "/app/../go.mod"},
+		{path: "/app/", wantIndex: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			// The client follows the redirect the mux sends for paths
+			// containing "..".
+			resp, err := http.Get(server.URL + tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if strings.Contains(string(body), secret) {
+				t.Fatalf("GET %s served a file from the working directory", tt.path)
+			}
+			if tt.wantIndex {
+				if resp.StatusCode != http.StatusOK || !strings.Contains(string(body), "Welcome to Chirpy") {
+					t.Fatalf("GET %s = %d, want the index page", tt.path, resp.StatusCode)
+				}
+				return
+			}
+			if resp.StatusCode != http.StatusNotFound {
+				t.Fatalf("GET %s = %d, want %d", tt.path, resp.StatusCode, http.StatusNotFound)
+			}
+		})
+	}
+}
@@ -2,30 +2,15 @@ package main
 
 import (
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
-
-func respondWithError(w http.ResponseWriter, code int, msg string) {
-    if code > 499 {
-        log.Printf("Responding with 5XX error: %s", msg)
-    }
-
-    type errorResponse struct {
-        Error string `json:"error"`
-    }
-
-    respondWithJson(w, code, errorResponse {
-        Error: msg,
-    })
-}
-
 func respondWithJson(w http.ResponseWriter, code int, payload interface{}) {
     w.Header().Set("Content-Type", "application/json")
     dat, err := json.Marshal(payload)
     if err != nil {
-        log.Printf("Error marshalling JSON: %s", err)
+        slog.Error("Couldn't marshal JSON", "error", err)
         w.WriteHeader(500)
         return
     }
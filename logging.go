@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// newLogger builds the application logger from LOG_FORMAT (text or json) and
+// LOG_LEVEL (debug, info, warn or error).
+func newLogger(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("LOG_LEVEL must be one of debug, info, warn or error, got %q", level)
+	}
+
+	options := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "text":
+		return slog.New(slog.NewTextHandler(w, options)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, options)), nil
+	default:
+		return nil, fmt.Errorf("LOG_FORMAT must be text or json, got %q", format)
+	}
+}
+
+// requestLogger returns a logger tagged with the request ID, the trace ID
+// when tracing is on and, once authenticated, the caller's user ID.
+func requestLogger(r *http.Request) *slog.Logger {
+	info := requestInfoFromContext(r.Context())
+	logger := slog.Default()
+	if info.ID != "" {
+		logger = logger.With("request_id", info.ID)
+	}
+	if span := trace.SpanContextFromContext(r.Context()); span.HasTraceID() {
+		logger = logger.With("trace_id", span.TraceID().String())
+	}
+	if info.UserID != 0 {
+		logger = logger.With("user_id", info.UserID)
+	}
+	return logger
+}
+
+// fatal logs an error that stops the server from starting and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
@@ -1,73 +1,333 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
 
 	"github.com/joho/godotenv"
+	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/config"
 	"github.com/keertirajmalik/chirpy/internal/database"
+	"github.com/keertirajmalik/chirpy/internal/lockout"
+	"github.com/keertirajmalik/chirpy/internal/mailer"
+	"github.com/keertirajmalik/chirpy/internal/oidc"
+	"github.com/keertirajmalik/chirpy/internal/web"
 )
 
 func main() {
-	const filepathRoot = "."
-	const port = "8080"
-
 	godotenv.Load(".env")
 
-	jwtSecret := os.Getenv("JWT_SECRET")
-	if jwtSecret == "" {
-		log.Fatal("JWT_SECRET enviornment variable is not set")
+	printConfig := flag.Bool("print-config", false, "Print the effective configuration, with secrets redacted, and exit")
+	dbg := flag.Bool("debug", false, "Enable debug mode")
+	cfg, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		os.Exit(2)
+	}
+
+	if *printConfig {
+		err := cfg.Print(os.Stdout)
+		if err != nil {
+			fatal("Couldn't print configuration", "error", err)
+		}
+		return
 	}
 
-	db, err := database.NewDB("database.json")
+	err = cfg.Validate()
 	if err != nil {
-		log.Fatal(err)
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
 	}
 
-	dbg := flag.Bool("debug", false, "Enable debug mode")
-	flag.Parse()
-	if dbg != nil && *dbg {
+	logger, err := newLogger(os.Stderr, cfg.Log.Format, cfg.Log.Level)
+	if err != nil {
+		fatal("Invalid logging configuration", "error", err)
+	}
+	slog.SetDefault(logger)
+
+	var oidcProvider *oidc.Provider
+	if cfg.OIDC.Issuer != "" {
+		oidcProvider = oidc.NewProvider(oidc.Config{
+			Issuer:       cfg.OIDC.Issuer,
+			ClientID:     cfg.OIDC.ClientID,
+			ClientSecret: cfg.OIDC.ClientSecret,
+			RedirectURL:  cfg.OIDC.RedirectURL,
+		})
+	}
+
+	err = auth.SetPasswordHashParams(cfg.PasswordHashParams())
+	if err != nil {
+		fatal("Invalid password hashing configuration", "error", err)
+	}
+
+	passwordPolicy := auth.PasswordPolicy{
+		MinLength: cfg.Password.MinLength,
+		MaxBytes:  cfg.Password.MaxBytes,
+	}
+	if cfg.Password.BreachedPath != "" {
+		breached, err := auth.LoadBreachedPasswords(cfg.Password.BreachedPath)
+		if err != nil {
+			fatal("Couldn't load breached passwords", "error", err)
+		}
+		passwordPolicy.Breached = breached
+	}
+
+	var mail mailer.Mailer
+	switch cfg.Mail.Mailer {
+	case "smtp":
+		mail = mailer.SMTPMailer{
+			Addr:     cfg.Mail.SMTPAddr,
+			From:     cfg.MailFrom(),
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+		}
+	case "file":
+		mail = &mailer.FileMailer{
+			Path: cfg.Mail.File,
+			From: cfg.MailFrom(),
+		}
+	case "log":
+		mail = mailer.LogMailer{}
+	}
+
+	shutdownTracing, err := setupTracing(context.Background(), cfg.Tracing.Exporter)
+	if err != nil {
+		fatal("Invalid tracing configuration", "error", err)
+	}
+
+	dummyPasswordHash, err := auth.HashPassword(context.Background(), "chirpy-dummy-password")
+	if err != nil {
+		fatal("Couldn't hash dummy password", "error", err)
+	}
+
+	db, err := database.NewDB(cfg.Database.Path)
+	if err != nil {
+		fatal("Couldn't open database", "error", err)
+	}
+
+	if args := flag.Args(); len(args) > 0 {
+		if args[0] != "create-admin" {
+			fatal("Unknown command", "command", args[0])
+		}
+		err := runCreateAdmin(context.Background(), db, passwordPolicy, args[1:])
+		if err != nil {
+			fatal("Couldn't create admin", "error", err)
+		}
+		return
+	}
+
+	if *dbg {
 		err := db.ResetDB()
 		if err != nil {
-			log.Fatal(err)
+			fatal("Couldn't reset database", "error", err)
 		}
 	}
 
+	badWords := map[string]struct{}{}
+	for _, word := range cfg.Chirps.BadWords {
+		badWords[strings.ToLower(word)] = struct{}{}
+	}
+
+	appMetrics := newMetrics()
+	db.SetObserver(appMetrics.observeDB)
+
 	config := apiConfig{
-		fileServerHits: 0,
-		DB:             db,
-		jwtSecret:      jwtSecret,
+		metrics:   appMetrics,
+		DB:        db,
+		jwtSecret: cfg.Auth.JWTSecret,
+
+		accessTokenTTL:     cfg.Auth.AccessTokenTTL,
+		accessTokenMaxTTL:  cfg.Auth.AccessTokenMaxTTL,
+		refreshTokenTTL:    cfg.Auth.RefreshTokenTTL,
+		refreshTokenMaxTTL: cfg.Auth.RefreshTokenMaxTTL,
+
+		oidc:            oidcProvider,
+		oidcRedirectURL: cfg.OIDC.RedirectURL,
+
+		accountLockout: lockout.New(lockout.Config{
+			Threshold:  cfg.Lockout.AccountThreshold,
+			BaseDelay:  cfg.Lockout.BaseDelay,
+			MaxDelay:   cfg.Lockout.MaxDelay,
+			ResetAfter: cfg.Lockout.ResetAfter,
+		}),
+		ipLockout: lockout.New(lockout.Config{
+			Threshold:  cfg.Lockout.IPThreshold,
+			BaseDelay:  cfg.Lockout.BaseDelay,
+			MaxDelay:   cfg.Lockout.MaxDelay,
+			ResetAfter: cfg.Lockout.ResetAfter,
+		}),
+		dummyPasswordHash: dummyPasswordHash,
+
+		passwordPolicy: passwordPolicy,
+
+		mailer:    mail,
+		publicURL: cfg.Server.PublicURL,
+
+		accountDeletionGracePeriod: cfg.Accounts.DeletionGracePeriod,
+
+		polkaKey: cfg.Polka.Key,
+
+		maxChirpLength:    cfg.Chirps.MaxLength,
+		maxRedChirpLength: cfg.Chirps.RedMaxLength,
+		badWords:          badWords,
 	}
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	config.background.Add(1)
+	go func() {
+		defer config.background.Done()
+		config.purgeDeletedUsers(ctx, cfg.Accounts.PurgeInterval)
+	}()
+
 	mux := http.NewServeMux()
 
-	mux.Handle("GET /app/*", config.middlewareMetricsInc(http.StripPrefix("/app/", http.FileServer(http.Dir(".")))))
+	webApp, err := web.New("/app/")
+	if err != nil {
+		fatal("Couldn't load web app", "error", err)
+	}
+	mux.Handle("GET /app/", config.middlewareMetricsInc(http.StripPrefix("/app/", webApp)))
 
 	mux.HandleFunc("GET /api/healthz", handlerReadiness)
-	mux.HandleFunc("GET /admin/metrics", config.handleMetrics)
-	mux.HandleFunc("GET /api/reset", config.handleReset)
+	// Scrapers authenticate with the metrics token. Without one, only admins
+	// can read the metrics.
+	metricsHandler := config.middlewareRequireRole(auth.RoleAdmin, appMetrics.handler())
+	if cfg.Metrics.Token != "" {
+		metricsHandler = middlewareRequireStaticToken(cfg.Metrics.Token, appMetrics.handler())
+	}
+	mux.Handle("GET /metrics", metricsHandler)
+	mux.Handle("GET /admin/metrics", config.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(config.handleMetrics)))
+	mux.Handle("GET /api/reset", config.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(config.handleReset)))
+	mux.Handle("PUT /admin/users/{userID}/role", config.middlewareRequireRole(auth.RoleAdmin, http.HandlerFunc(config.handleUserSetRole)))
+	mux.Handle("POST /admin/users/{userID}/unlock", config.middlewareRequireRole(auth.RoleModerator, http.HandlerFunc(config.handleUserUnlock)))
+	mux.Handle("DELETE /admin/chirps/{chirpID}", config.middlewareRequireRole(auth.RoleModerator, http.HandlerFunc(config.handleModeratorChirpDelete)))
 
-	mux.HandleFunc("GET /api/chirps", config.handleChirpGet)
-	mux.HandleFunc("POST /api/chirps", config.handleChirpCreate)
-	mux.HandleFunc("GET /api/chirps/{chirpID}", config.handleChirpGetSpecific)
-	mux.HandleFunc("DELETE /api/chirps/{chirpID}", config.handleChirpDelete)
+	mux.Handle("GET /api/chirps", config.middlewareOptionalAuth(http.HandlerFunc(config.handleChirpGet)))
+	mux.Handle("POST /api/chirps", config.middlewareRequireAuth(http.HandlerFunc(config.handleChirpCreate)))
+	mux.Handle("GET /api/chirps/{chirpID}", config.middlewareOptionalAuth(http.HandlerFunc(config.handleChirpGetSpecific)))
+	mux.Handle("PUT /api/chirps/{chirpID}", config.middlewareRequireAuth(http.HandlerFunc(config.handleChirpUpdate)))
+	mux.Handle("DELETE /api/chirps/{chirpID}", config.middlewareRequireAuth(http.HandlerFunc(config.handleChirpDelete)))
 
 	mux.HandleFunc("POST /api/users", config.handleUsersCreate)
-	mux.HandleFunc("PUT /api/users", config.handleUsersUpdate)
+	// Legacy route, kept for older clients. It now follows PATCH rules, so
+	// changing email or password needs current_password.
+	mux.Handle("PUT /api/users", config.middlewareRequireAuth(http.HandlerFunc(config.handleUsersPatch)))
+	mux.Handle("PATCH /api/users/me", config.middlewareRequireAuth(http.HandlerFunc(config.handleUsersPatch)))
+	mux.Handle("DELETE /api/users/me", config.middlewareRequireAuth(http.HandlerFunc(config.handleUsersDelete)))
+	mux.Handle("POST /api/users/me/restore", config.middlewareRequireAuth(http.HandlerFunc(config.handleUsersRestore)))
+	mux.Handle("GET /api/users/me/export", config.middlewareRequireAuth(http.HandlerFunc(config.handleUsersExport)))
+	mux.Handle("GET /api/users/me/blocks", config.middlewareRequireAuth(http.HandlerFunc(config.handleBlocksList)))
+	mux.Handle("PUT /api/users/me/blocks/{userID}", config.middlewareRequireAuth(http.HandlerFunc(config.handleBlock)))
+	mux.Handle("DELETE /api/users/me/blocks/{userID}", config.middlewareRequireAuth(http.HandlerFunc(config.handleUnblock)))
+	mux.Handle("GET /api/users/me/mutes", config.middlewareRequireAuth(http.HandlerFunc(config.handleMutesList)))
+	mux.Handle("PUT /api/users/me/mutes/{userID}", config.middlewareRequireAuth(http.HandlerFunc(config.handleMute)))
+	mux.Handle("DELETE /api/users/me/mutes/{userID}", config.middlewareRequireAuth(http.HandlerFunc(config.handleUnmute)))
+	mux.HandleFunc("GET /api/users/{handle}", config.handleProfileGet)
+	mux.Handle("POST /api/users/verify-email/request", config.middlewareRequireAuth(http.HandlerFunc(config.handleVerifyEmailRequest)))
+	mux.HandleFunc("POST /api/users/verify-email/confirm", config.handleVerifyEmailConfirm)
+	mux.Handle("POST /api/users/me/totp", config.middlewareRequireAuth(http.HandlerFunc(config.handleTOTPEnroll)))
+	mux.Handle("POST /api/users/me/totp/confirm", config.middlewareRequireAuth(http.HandlerFunc(config.handleTOTPConfirm)))
 
 	mux.HandleFunc("POST /api/login", config.handleLogin)
+	mux.HandleFunc("POST /api/login/mfa", config.handleLoginMFA)
+	mux.HandleFunc("GET /api/login/oidc", config.handleOIDCLogin)
+	mux.HandleFunc("GET /api/login/oidc/callback", config.handleOIDCCallback)
 	mux.HandleFunc("POST /api/refresh", config.handleRefresh)
 	mux.HandleFunc("POST /api/revoke", config.handleRevoke)
+	mux.HandleFunc("POST /api/password-reset/request", config.handlePasswordResetRequest)
+	mux.HandleFunc("POST /api/password-reset/confirm", config.handlePasswordResetConfirm)
+
+	mux.Handle("POST /api/tokens", config.middlewareRequireAuth(http.HandlerFunc(config.handleAPITokenCreate)))
+	mux.Handle("GET /api/tokens", config.middlewareRequireAuth(http.HandlerFunc(config.handleAPITokenList)))
+	mux.Handle("DELETE /api/tokens/{tokenID}", config.middlewareRequireAuth(http.HandlerFunc(config.handleAPITokenRevoke)))
+
+	mux.HandleFunc("POST /api/polka/webhooks", config.handlePolkaWebhook)
+
+	mux.Handle("POST /api/oauth/clients", config.middlewareRequireAuth(http.HandlerFunc(config.handleOAuthClientCreate)))
+	mux.HandleFunc("GET /oauth/authorize", config.handleOAuthAuthorize)
+	mux.HandleFunc("POST /oauth/authorize", config.handleOAuthAuthorizeSubmit)
+	mux.HandleFunc("POST /oauth/token", config.handleOAuthToken)
+
+	handler := chain(mux,
+		middlewareRequestID,
+		middlewareTracing(mux),
+		middlewareAccessLog(mux),
+		appMetrics.middleware(mux),
+		middlewareRecover,
+		config.middlewareAuthenticate,
+	)
 
 	server := &http.Server{
-		Addr:    ":" + port,
-		Handler: mux,
+		Addr:              ":" + strconv.Itoa(cfg.Server.Port),
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	serveErrors := make(chan error, 1)
+	go func() {
+		serveErrors <- server.ListenAndServe()
+	}()
+	slog.Info("Serving", "port", cfg.Server.Port)
+
+	var serveErr error
+	select {
+	case serveErr = <-serveErrors:
+		slog.Error("Server stopped", "error", serveErr)
+	case <-ctx.Done():
+		slog.Info("Shutting down", "timeout", cfg.Server.ShutdownTimeout)
 	}
+	// A second signal kills the server straight away.
+	stop()
 
-	log.Printf("Serving on port: %s\n", port)
-	log.Fatal(server.ListenAndServe())
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	shutdown(shutdownCtx, server, &config, db, shutdownTracing)
+
+	if serveErr != nil {
+		os.Exit(1)
+	}
+	slog.Info("Stopped")
+}
+
+// shutdown stops accepting connections and waits, until ctx is done, for
+// in-flight requests and background work such as sending emails, then closes
+// the database and flushes traces.
+func shutdown(ctx context.Context, server *http.Server, config *apiConfig, db *database.DB, shutdownTracing func(context.Context) error) {
+	err := server.Shutdown(ctx)
+	if err != nil {
+		slog.Error("Couldn't finish in-flight requests", "error", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		config.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Error("Couldn't finish background work", "error", ctx.Err())
+	}
+
+	err = db.Close()
+	if err != nil {
+		slog.Error("Couldn't close database", "error", err)
+	}
+
+	err = shutdownTracing(ctx)
+	if err != nil {
+		slog.Error("Couldn't flush traces", "error", err)
+	}
 }
 
 func handlerReadiness(writer http.ResponseWriter, request *http.Request) {
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// metrics holds the Prometheus collectors for the server. They are
+// registered on their own registry rather than the global one so nothing
+// else in the process can add to what /metrics exposes.
+type metrics struct {
+	registry *prometheus.Registry
+
+	requests         *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
+	dbDuration       *prometheus.HistogramVec
+
+	fileServerHits prometheus.Counter
+	chirpsCreated  prometheus.Counter
+	loginsFailed   prometheus.Counter
+
+	// Counters can't go down, so resetting the hit count on the admin page
+	// only moves the point it counts from.
+	fileServerHitsReset atomic.Uint64
+}
+
+func newMetrics() *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "chirpy_http_requests_total",
+			Help: "HTTP requests handled, by route and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chirpy_http_request_duration_seconds",
+			Help:    "Time taken to handle HTTP requests, by route.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		requestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "chirpy_http_requests_in_flight",
+			Help: "HTTP requests currently being handled.",
+		}),
+		dbDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "chirpy_db_operation_duration_seconds",
+			Help:    "Time taken to load or write the database file.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{"operation"}),
+		fileServerHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chirpy_fileserver_hits_total",
+			Help: "Requests for the web app under /app/.",
+		}),
+		chirpsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chirpy_chirps_created_total",
+			Help: "Chirps posted.",
+		}),
+		loginsFailed: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "chirpy_logins_failed_total",
+			Help: "Logins rejected for a wrong email, password or second factor.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.requests,
+		m.requestDuration,
+		m.requestsInFlight,
+		m.dbDuration,
+		m.fileServerHits,
+		m.chirpsCreated,
+		m.loginsFailed,
+	)
+
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeDB is a database.Observer.
+func (m *metrics) observeDB(operation string, duration time.Duration) {
+	m.dbDuration.WithLabelValues(operation).Observe(duration.Seconds())
+}
+
+// fileServerHitCount reads the hit count from the registry, counting from
+// the last reset.
+func (m *metrics) fileServerHitCount() int {
+	metric := &dto.Metric{}
+	err := m.fileServerHits.Write(metric)
+	if err != nil {
+		return 0
+	}
+	return int(uint64(metric.GetCounter().GetValue()) - m.fileServerHitsReset.Load())
+}
+
+func (m *metrics) resetFileServerHits() {
+	metric := &dto.Metric{}
+	err := m.fileServerHits.Write(metric)
+	if err != nil {
+		return
+	}
+	m.fileServerHitsReset.Store(uint64(metric.GetCounter().GetValue()))
+}
+
+// middleware records the count, latency and status of every request
+// by the mux pattern that matched, so routes with path parameters don't
+// create a series per ID.
+func (m *metrics) middleware(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			m.requestsInFlight.Inc()
+			defer m.requestsInFlight.Dec()
+
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+
+			next.ServeHTTP(rec, r)
+
+			_, route := mux.Handler(r)
+			if route == "" {
+				route = "unmatched"
+			}
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			m.requests.WithLabelValues(r.Method, route, strconv.Itoa(rec.status)).Inc()
+			m.requestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		})
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"time"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+)
+
+const requestIDHeader = "X-Request-ID"
+
+// validRequestID limits which request IDs from clients or proxies are passed
+// on, so they are safe to echo back and to log.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// chain applies middlewares to a handler so the first one listed runs first.
+func chain(handler http.Handler, middlewares ...func(http.Handler) http.Handler) http.Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+type requestInfoContextKey struct{}
+
+// requestInfo describes the request for logging. It is shared by pointer so
+// middlewares further in, such as authentication, can fill it in for the
+// access log.
+type requestInfo struct {
+	ID     string
+	UserID int
+}
+
+func requestInfoFromContext(ctx context.Context) *requestInfo {
+	info, ok := ctx.Value(requestInfoContextKey{}).(*requestInfo)
+	if !ok {
+		return &requestInfo{}
+	}
+	return info
+}
+
+// middlewareRequestID gives every request an ID, reusing one set by the
+// client or a proxy in front of us, and echoes it in the response.
+func middlewareRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestInfoContextKey{}, &requestInfo{ID: id})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func newRequestID() string {
+	id := make([]byte, 16)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(id)
+}
+
+// statusRecorder remembers the status code and size of a response.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// middlewareAccessLog logs one line per request. The route is the mux
+// pattern that matched rather than the path, so requests for different
+// chirps are grouped together.
+func middlewareAccessLog(mux *http.ServeMux) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w}
+
+			next.ServeHTTP(rec, r)
+
+			_, route := mux.Handler(r)
+			requestLogger(r).Info("Request",
+				"method", r.Method,
+				"route", route,
+				"status", rec.status,
+				"latency", time.Since(start),
+				"bytes", rec.bytes,
+			)
+		})
+	}
+}
+
+// middlewareRequireStaticToken only lets through requests bearing token, for
+// endpoints such as /metrics that are called by other services rather than
+// users.
+func middlewareRequireStaticToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, err := auth.GetBearerToken(r.Header)
+		if err != nil || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			respondWithError(w, r, problemInvalidToken, "Couldn't validate token")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middlewareRecover turns a panicking handler into a 500 response instead of
+// a dropped connection.
+func middlewareRecover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+
+			requestLogger(r).Error("Recovered from panic", "panic", err, "stack", string(debug.Stack()))
+
+			// Too late to send an error if the handler already started
+			// its response.
+			if rec, ok := w.(*statusRecorder); ok && rec.status != 0 {
+				return
+			}
+			respondWithError(w, r, problemInternal, "Internal server error")
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/keertirajmalik/chirpy/internal/auth"
+	"github.com/keertirajmalik/chirpy/internal/database"
+)
+
+const (
+	tokenTypeAccess   = "access"
+	tokenTypeAPIToken = "api_token"
+	tokenTypeOAuth    = "oauth"
+)
+
+var errRefreshTokenAsAccessToken = errors.New("refresh token used as an access token")
+
+// principal is the authenticated caller of a request, whichever kind of
+// token they presented.
+type principal struct {
+	UserID    int
+	Role      string
+	Scopes    []string
+	TokenType string
+}
+
+func (p principal) hasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// authenticate resolves the bearer token on the request to a principal. It
+// accepts access tokens issued at login or to OAuth clients as well as
+// personal access tokens. Only login sessions carry the user's role; other
+// tokens act with the privileges of a plain user.
+func (cfg *apiConfig) authenticate(request *http.Request) (principal, error) {
+	token, err := auth.GetBearerToken(request.Header)
+	if err != nil {
+		return principal{}, err
+	}
+
+	if auth.IsAPIToken(token) {
+		apiToken, err := cfg.DB.GetAPITokenByHash(request.Context(), auth.HashToken(token))
+		if err != nil {
+			return principal{}, err
+		}
+
+		return principal{
+			UserID:    apiToken.UserID,
+			Role:      auth.RoleUser,
+			Scopes:    apiToken.Scopes,
+			TokenType: tokenTypeAPIToken,
+		}, nil
+	}
+
+	claims, err := auth.ParseJWT(token, cfg.jwtSecret)
+	if err != nil {
+		// Spot refresh tokens by their shape, so a bad token never costs a
+		// database lookup.
+		if auth.IsRefreshToken(token) {
+			return principal{}, errRefreshTokenAsAccessToken
+		}
+		return principal{}, err
+	}
+
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		return principal{}, err
+	}
+
+	if claims.ClientID != "" {
+		return principal{
+			UserID:    userID,
+			Role:      auth.RoleUser,
+			Scopes:    strings.Fields(claims.Scope),
+			TokenType: tokenTypeOAuth,
+		}, nil
+	}
+
+	return principal{
+		UserID:    userID,
+		Role:      claims.Role,
+		Scopes:    auth.AllScopes,
+		TokenType: tokenTypeAccess,
+	}, nil
+}
+
+type principalContextKey struct{}
+
+// authResult is what middlewareAuthenticate stores in the request context:
+// either the caller or why their token was rejected.
+type authResult struct {
+	principal principal
+	err       error
+}
+
+// middlewareAuthenticate validates the bearer token once for every request
+// and stores the caller in the request context. It never rejects a request
+// itself, since some endpoints take other kinds of bearer token; routes
+// choose with middlewareRequireAuth or middlewareOptionalAuth.
+func (cfg *apiConfig) middlewareAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		p, err := cfg.authenticate(r)
+		if err == nil {
+			requestInfoFromContext(r.Context()).UserID = p.UserID
+		}
+		ctx := context.WithValue(r.Context(), principalContextKey{}, authResult{principal: p, err: err})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// principalFromContext returns the caller stored by middlewareAuthenticate,
+// or auth.ErrorNoAuthHeaderIncluded for an anonymous request.
+func principalFromContext(ctx context.Context) (principal, error) {
+	result, ok := ctx.Value(principalContextKey{}).(authResult)
+	if !ok {
+		return principal{}, auth.ErrorNoAuthHeaderIncluded
+	}
+	return result.principal, result.err
+}
+
+// middlewareRequireAuth only lets through requests with a valid access token.
+func (cfg *apiConfig) middlewareRequireAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := principalFromContext(r.Context())
+		if err != nil {
+			respondWithAuthError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// middlewareOptionalAuth lets anonymous requests through but rejects ones
+// with an invalid token, rather than silently treating them as anonymous.
+func (cfg *apiConfig) middlewareOptionalAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := principalFromContext(r.Context())
+		if err != nil && !errors.Is(err, auth.ErrorNoAuthHeaderIncluded) {
+			respondWithAuthError(w, r, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func respondWithAuthError(writer http.ResponseWriter, request *http.Request, err error) {
+	if errors.Is(err, errRefreshTokenAsAccessToken) {
+		respondWithError(writer, request, problemInvalidToken, "Refresh tokens can't be used as access tokens")
+		return
+	}
+
+	respondWithError(writer, request, problemInvalidToken, "Couldn't validate token")
+}
+
+// authorize checks the caller holds scope, responding with an error and
+// returning false if not.
+func (cfg *apiConfig) authorize(writer http.ResponseWriter, request *http.Request, scope string) (principal, bool) {
+	p, err := principalFromContext(request.Context())
+	if err != nil {
+		respondWithAuthError(writer, request, err)
+		return principal{}, false
+	}
+
+	if !p.hasScope(scope) {
+		respondWithError(writer, request, problemInsufficientScope, "Token is missing the "+scope+" scope")
+		return principal{}, false
+	}
+
+	return p, true
+}
+
+// authorizeSession is like authorize but only accepts the user's own login
+// session, for endpoints that manage credentials and so must not be reachable
+// with a personal access token or by an OAuth client.
+func (cfg *apiConfig) authorizeSession(writer http.ResponseWriter, request *http.Request) (principal, bool) {
+	p, err := principalFromContext(request.Context())
+	if err != nil {
+		respondWithAuthError(writer, request, err)
+		return principal{}, false
+	}
+
+	if p.TokenType != tokenTypeAccess {
+		respondWithError(writer, request, problemSessionRequired, "This endpoint requires a login session")
+		return principal{}, false
+	}
+
+	return p, true
+}
+
+// middlewareRequireRole only lets through requests from a login session
+// whose user holds at least role.
+func (cfg *apiConfig) middlewareRequireRole(role string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, ok := cfg.authorizeSession(w, r)
+		if !ok {
+			return
+		}
+
+		// The role in the token may be stale, so check the one the user
+		// holds now in case it has been taken away.
+		user, err := cfg.DB.GetUser(r.Context(), p.UserID)
+		if errors.Is(err, database.ErrNotExist) {
+			respondWithError(w, r, problemInvalidToken, "User no longer exists")
+			return
+		}
+		if err != nil {
+			respondWithInternalError(w, r, "Couldn't get user", err)
+			return
+		}
+
+		if !auth.HasRole(user.Role, role) {
+			respondWithError(w, r, problemInsufficientRole, "This endpoint requires the "+role+" role")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
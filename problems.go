@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strconv"
+)
+
+// problemType is a kind of error the API returns. Its code is stable so
+// clients can match on it, and it always comes with the same status and
+// title; the detail of each response says what went wrong that time.
+type problemType struct {
+	code   string
+	status int
+	title  string
+}
+
+var (
+	problemMalformedRequest   = problemType{"malformed_request", http.StatusBadRequest, "Malformed request"}
+	problemInvalidLoginState  = problemType{"invalid_login_state", http.StatusBadRequest, "Invalid login state"}
+	problemInvalidToken       = problemType{"invalid_token", http.StatusUnauthorized, "Invalid or missing token"}
+	problemInvalidCredentials = problemType{"invalid_credentials", http.StatusUnauthorized, "Invalid credentials"}
+	problemInvalidMFACode     = problemType{"invalid_mfa_code", http.StatusUnauthorized, "Invalid two-factor code"}
+	problemInvalidAPIKey      = problemType{"invalid_api_key", http.StatusUnauthorized, "Invalid API key"}
+	problemSSOFailed          = problemType{"sso_failed", http.StatusUnauthorized, "Single sign-on failed"}
+	problemForbidden          = problemType{"forbidden", http.StatusForbidden, "Forbidden"}
+	problemInsufficientScope  = problemType{"insufficient_scope", http.StatusForbidden, "Insufficient scope"}
+	problemInsufficientRole   = problemType{"insufficient_role", http.StatusForbidden, "Insufficient role"}
+	problemSessionRequired    = problemType{"session_required", http.StatusForbidden, "Login session required"}
+	problemPasswordNotSet     = problemType{"password_not_set", http.StatusForbidden, "Password not set"}
+	problemChirpyRedRequired  = problemType{"chirpy_red_required", http.StatusForbidden, "Chirpy Red required"}
+	problemNotFound           = problemType{"not_found", http.StatusNotFound, "Not found"}
+	problemChirpNotFound      = problemType{"chirp_not_found", http.StatusNotFound, "Chirp not found"}
+	problemUserNotFound       = problemType{"user_not_found", http.StatusNotFound, "User not found"}
+	problemTokenNotFound      = problemType{"token_not_found", http.StatusNotFound, "Token not found"}
+	problemConflict           = problemType{"conflict", http.StatusConflict, "Conflict"}
+	problemEmailTaken         = problemType{"email_taken", http.StatusConflict, "Email already in use"}
+	problemHandleTaken        = problemType{"handle_taken", http.StatusConflict, "Handle already taken"}
+	problemValidation         = problemType{"validation_failed", http.StatusUnprocessableEntity, "Validation failed"}
+	problemTooManyAttempts    = problemType{"too_many_attempts", http.StatusTooManyRequests, "Too many attempts"}
+	problemInternal           = problemType{"internal_error", http.StatusInternalServerError, "Internal server error"}
+	problemUpstream           = problemType{"upstream_error", http.StatusBadGateway, "Upstream service error"}
+)
+
+// problem is an RFC 7807 problem details response, extended with the code,
+// the request ID and any per-field errors.
+type problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Errors    []fieldError `json:"errors,omitempty"`
+}
+
+// fieldError points at a single field of the request that was rejected.
+// Validators return it as an error.
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e fieldError) Error() string {
+	return e.Message
+}
+
+func respondWithError(w http.ResponseWriter, r *http.Request, kind problemType, detail string, fieldErrors ...fieldError) {
+	body, err := json.Marshal(problem{
+		Type:      "urn:chirpy:problem:" + kind.code,
+		Title:     kind.title,
+		Status:    kind.status,
+		Detail:    detail,
+		Instance:  r.URL.Path,
+		Code:      kind.code,
+		RequestID: w.Header().Get(requestIDHeader),
+		Errors:    fieldErrors,
+	})
+	if err != nil {
+		requestLogger(r).Error("Couldn't marshal problem", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(kind.status)
+	w.Write(body)
+}
+
+// respondWithInternalError logs the real cause of a 5XX error, which the
+// client never sees, before responding with msg.
+func respondWithInternalError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	requestLogger(r).Error(msg, "error", err)
+	respondWithError(w, r, problemInternal, msg)
+}
+
+// respondWithValidationError rejects a request because of the fields named by
+// err, which should be a fieldError or several joined with errors.Join.
+func respondWithValidationError(w http.ResponseWriter, r *http.Request, err error) {
+	fieldErrs, ok := fieldErrors(err)
+	if !ok {
+		respondWithInternalError(w, r, "Couldn't validate request", err)
+		return
+	}
+
+	detail := fieldErrs[0].Message
+	if len(fieldErrs) > 1 {
+		detail = strconv.Itoa(len(fieldErrs)) + " fields are invalid"
+	}
+	respondWithError(w, r, problemValidation, detail, fieldErrs...)
+}
+
+// fieldErrors collects the fieldErrors in err, reporting false if anything
+// else went wrong.
+func fieldErrors(err error) ([]fieldError, bool) {
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		all := []fieldError{}
+		for _, err := range joined.Unwrap() {
+			fieldErrs, ok := fieldErrors(err)
+			if !ok {
+				return nil, false
+			}
+			all = append(all, fieldErrs...)
+		}
+		return all, len(all) > 0
+	}
+
+	fieldErr := fieldError{}
+	if !errors.As(err, &fieldErr) {
+		return nil, false
+	}
+	return []fieldError{fieldErr}, true
+}
+
+// respondWithDecodeError explains why the request body couldn't be decoded,
+// naming the field when it has the wrong type.
+func respondWithDecodeError(w http.ResponseWriter, r *http.Request, err error) {
+	typeErr := &json.UnmarshalTypeError{}
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		message := "Must be " + jsonTypeName(typeErr.Type)
+		respondWithError(w, r, problemMalformedRequest, typeErr.Field+" has the wrong type", fieldError{
+			Field:   typeErr.Field,
+			Code:    "invalid_type",
+			Message: message,
+		})
+		return
+	}
+
+	if errors.Is(err, io.EOF) {
+		respondWithError(w, r, problemMalformedRequest, "Request body is empty")
+		return
+	}
+
+	respondWithError(w, r, problemMalformedRequest, "Request body isn't valid JSON")
+}
+
+func jsonTypeName(typ reflect.Type) string {
+	switch typ.Kind() {
+	case reflect.String:
+		return "a string"
+	case reflect.Bool:
+		return "a boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "a number"
+	case reflect.Slice, reflect.Array:
+		return "an array"
+	default:
+		return "an object"
+	}
+}